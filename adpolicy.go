@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// adrule is what to do with one kind of ad offer
+type adrule struct {
+	Accept   bool `json:"accept"`
+	DailyCap int  `json:"daily_cap,omitempty"` // 0 is no cap
+}
+
+// adpolicy decides which ads are watched. Offers are named after the
+// template that shows what's offered, the boosts dialog is boosts_watch_ad
+// and the daily videos daily_video_button and timed_video_button
+type adpolicy struct {
+	Offers  map[string]adrule `json:"offers"`
+	Unknown adrule            `json:"unknown"`  // offers no template matches
+	Hours   [2]int            `json:"hours"`    // local hours ads are watched in, from and up to
+	Spacing duration          `json:"spacing"`  // between ads
+	Counts  string            `json:"counters"` // file the daily counts are kept in
+
+	counters adcounters
+	playing  string // offer of the ad playing now, counted once it's done
+}
+
+// adcounters are the ads watched today, kept across restarts
+type adcounters struct {
+	Day    string         `json:"day"`
+	Counts map[string]int `json:"counts"`
+	Last   time.Time      `json:"last"`
+}
+
+var defaultadpolicy = adpolicy{
+	Offers: map[string]adrule{
+		"ad_offer_boost":             {Accept: true},
+		"ad_offer_eggs":              {Accept: true},
+		"ad_offer_box_of_eggs":       {Accept: true},
+		"ad_offer_crate_of_eggs":     {Accept: true},
+		"ad_offer_chicken_box":       {Accept: true},
+		"ad_offer_large_chicken_box": {Accept: true},
+		"ad_offer_tickets":           {Accept: true},
+		"ad_offer_money":             {Accept: false},
+		"ad_offer_a_ton_of_cash":     {Accept: false},
+		"boosts_watch_ad":            {Accept: true},
+		"daily_video_button":         {Accept: true},
+		"timed_video_button":         {Accept: true},
+	},
+	Unknown: adrule{Accept: true},
+	Hours:   [2]int{0, 24},
+	Counts:  "ad_counters.json",
+}
+
+func loadadpolicy(filename string) (*adpolicy, error) {
+	ap := defaultadpolicy
+	// Offers in the file replace the built in ones one by one
+	ap.Offers = make(map[string]adrule)
+	for offer, ar := range defaultadpolicy.Offers {
+		ap.Offers[offer] = ar
+	}
+	if _, err := loadjson(filename, &ap); err != nil {
+		return nil, err
+	}
+
+	ap.counters = adcounters{Counts: make(map[string]int)}
+	if _, err := loadjson(ap.Counts, &ap.counters); err != nil {
+		return nil, err
+	}
+	if ap.counters.Counts == nil {
+		ap.counters.Counts = make(map[string]int)
+	}
+	return &ap, nil
+}
+
+// today resets the counters when the day changes
+func (ap *adpolicy) today(now time.Time) {
+	day := now.Format("2006-01-02")
+	if ap.counters.Day != day {
+		ap.counters.Day = day
+		ap.counters.Counts = make(map[string]int)
+	}
+}
+
+// rule is the rule for an offer, and what it's counted as
+func (ap *adpolicy) rule(offer string) (string, adrule) {
+	if ar, known := ap.Offers[offer]; known {
+		return offer, ar
+	}
+	return "unknown", ap.Unknown
+}
+
+// decide is whether to watch the offered ad, and why
+func (ap *adpolicy) decide(offer string, now time.Time) (bool, string) {
+	ap.today(now)
+	offer, ar := ap.rule(offer)
+	if !ar.Accept {
+		return false, offer + " is rejected"
+	}
+	if !ap.inhours(now.Hour()) {
+		return false, fmt.Sprintf("only watching ads from %v to %v", ap.Hours[0], ap.Hours[1])
+	}
+	if since := now.Sub(ap.counters.Last); since < time.Duration(ap.Spacing) {
+		return false, fmt.Sprintf("last ad was only %v ago", since.Round(time.Second))
+	}
+	if ar.DailyCap > 0 && ap.counters.Counts[offer] >= ar.DailyCap {
+		return false, fmt.Sprintf("watched %v of %v today already", ap.counters.Counts[offer], offer)
+	}
+	return true, "accepting " + offer
+}
+
+// inhours is whether ads are watched in an hour. Hours that wrap past
+// midnight, like 22 to 6, are from the evening into the morning
+func (ap *adpolicy) inhours(hour int) bool {
+	from, to := ap.Hours[0], ap.Hours[1]
+	if from <= to {
+		return hour >= from && hour < to
+	}
+	return hour >= from || hour < to
+}
+
+// bestoffer is the offer template that matched best, scores are how far
+// each template is from its threshold, lower is better
+func bestoffer(scores map[string]float32) (string, bool) {
+	best, found := "", false
+	for name, score := range scores {
+		if !strings.HasPrefix(name, "ad_offer_") || strings.HasSuffix(name, "_button") {
+			continue
+		}
+		if !found || score < scores[best] || (score == scores[best] && name < best) {
+			best, found = name, true
+		}
+	}
+	return best, found
+}
+
+// started is called when an ad starts playing, it's counted once it's done
+func (ap *adpolicy) started(offer string) {
+	ap.playing = offer
+}
+
+// finished counts the ad that was playing
+func (ap *adpolicy) finished(now time.Time) {
+	if ap.playing != "" {
+		ap.watched(ap.playing, now)
+		ap.playing = ""
+	}
+}
+
+// abandoned is an ad that timed out. It counts too, or an ad that never
+// ends would be started again and again past its cap
+func (ap *adpolicy) abandoned(now time.Time) {
+	ap.finished(now)
+}
+
+// watched counts an ad and saves the counters
+func (ap *adpolicy) watched(offer string, now time.Time) {
+	ap.today(now)
+	offer, _ = ap.rule(offer)
+	ap.counters.Counts[offer]++
+	ap.counters.Last = now
+
+	data, err := json.MarshalIndent(ap.counters, "", "  ")
+	if err == nil {
+		err = os.WriteFile(ap.Counts, data, 0644)
+	}
+	if err != nil {
+		fmt.Printf("Could not save ad counters: %v\n", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdDecide(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		offer  string
+		counts map[string]int
+		day    string // of the counts, today if blank
+		last   time.Duration
+		want   bool
+	}{
+		{name: "accepted", offer: "ad_offer_eggs", want: true},
+		{name: "rejected", offer: "ad_offer_money"},
+		{name: "unknown offer", offer: "ad_offer_spaceship", want: true},
+		{name: "under the cap", offer: "ad_offer_eggs", counts: map[string]int{"ad_offer_eggs": 1}, want: true},
+		{name: "at the cap", offer: "ad_offer_eggs", counts: map[string]int{"ad_offer_eggs": 2}},
+		{name: "cap of another offer", offer: "ad_offer_boost", counts: map[string]int{"ad_offer_eggs": 2}, want: true},
+		{name: "capped yesterday", offer: "ad_offer_eggs", counts: map[string]int{"ad_offer_eggs": 2}, day: "2026-10-18", want: true},
+		{name: "unknown offers share a cap", offer: "ad_offer_spaceship", counts: map[string]int{"unknown": 1}},
+		{name: "too soon after the last", offer: "ad_offer_eggs", last: 4 * time.Minute},
+		{name: "spaced enough", offer: "ad_offer_eggs", last: 5 * time.Minute, want: true},
+	}
+	for _, test := range tests {
+		ap := defaultadpolicy
+		ap.Offers = map[string]adrule{
+			"ad_offer_eggs":  {Accept: true, DailyCap: 2},
+			"ad_offer_boost": {Accept: true},
+			"ad_offer_money": {Accept: false},
+		}
+		ap.Unknown = adrule{Accept: true, DailyCap: 1}
+		ap.Spacing = duration(5 * time.Minute)
+		ap.counters = adcounters{Day: test.day, Counts: test.counts, Last: now.Add(-time.Hour)}
+		if ap.counters.Day == "" {
+			ap.counters.Day = now.Format("2006-01-02")
+		}
+		if ap.counters.Counts == nil {
+			ap.counters.Counts = make(map[string]int)
+		}
+		if test.last > 0 {
+			ap.counters.Last = now.Add(-test.last)
+		}
+		if got, why := ap.decide(test.offer, now); got != test.want {
+			t.Errorf("%v: decided %v (%v), want %v", test.name, got, why, test.want)
+		}
+	}
+}
+
+func TestAdHours(t *testing.T) {
+	tests := []struct {
+		hours [2]int
+		hour  int
+		want  bool
+	}{
+		{hours: [2]int{0, 24}, hour: 0, want: true},
+		{hours: [2]int{0, 24}, hour: 23, want: true},
+		{hours: [2]int{8, 20}, hour: 7},
+		{hours: [2]int{8, 20}, hour: 8, want: true},
+		{hours: [2]int{8, 20}, hour: 19, want: true},
+		{hours: [2]int{8, 20}, hour: 20},
+		// Overnight
+		{hours: [2]int{22, 6}, hour: 21},
+		{hours: [2]int{22, 6}, hour: 22, want: true},
+		{hours: [2]int{22, 6}, hour: 23, want: true},
+		{hours: [2]int{22, 6}, hour: 0, want: true},
+		{hours: [2]int{22, 6}, hour: 5, want: true},
+		{hours: [2]int{22, 6}, hour: 6},
+		{hours: [2]int{22, 6}, hour: 12},
+	}
+	for _, test := range tests {
+		ap := adpolicy{Hours: test.hours}
+		if got := ap.inhours(test.hour); got != test.want {
+			t.Errorf("hours %v, inhours(%v) = %v, want %v", test.hours, test.hour, got, test.want)
+		}
+	}
+}
+
+// Ads count when they finish or time out, and only once
+func TestAdCounting(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.Local)
+	ap := defaultadpolicy
+	ap.Counts = filepath.Join(t.TempDir(), "ad_counters.json")
+	ap.counters = adcounters{Counts: make(map[string]int)}
+
+	ap.started("ad_offer_eggs")
+	ap.finished(now)
+	ap.finished(now)
+	ap.started("ad_offer_boost")
+	ap.abandoned(now)
+	ap.started("ad_offer_spaceship")
+	ap.finished(now)
+
+	want := map[string]int{"ad_offer_eggs": 1, "ad_offer_boost": 1, "unknown": 1}
+	for offer, count := range want {
+		if ap.counters.Counts[offer] != count {
+			t.Errorf("%v counted %v times, want %v", offer, ap.counters.Counts[offer], count)
+		}
+	}
+	if len(ap.counters.Counts) != len(want) {
+		t.Errorf("counts are %v, want %v", ap.counters.Counts, want)
+	}
+
+	// A counters file without counts still counts
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "ad_policy.json")
+	counters := filepath.Join(dir, "ad_counters.json")
+	if err := os.WriteFile(policy, []byte(fmt.Sprintf(`{"counters": %q}`, counters)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(counters, []byte(`{"day": "2026-10-19", "counts": null}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadadpolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.watched("ad_offer_eggs", now)
+	if loaded.counters.Counts["ad_offer_eggs"] != 1 {
+		t.Errorf("counts after loading %v are %v", counters, loaded.counters.Counts)
+	}
+}
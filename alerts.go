@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// alerter tells the player about things the bot can't fix by itself. Alerts
+// are always logged, and posted as plain text to url if there is one (ntfy.sh
+// and most chat webhooks take that). The same alert is only sent once per
+// every, an hour by default
+type alerter struct {
+	url   string
+	every time.Duration
+	sent  map[string]time.Time
+}
+
+func newalerter(url string) *alerter {
+	return &alerter{
+		url:   url,
+		every: time.Hour,
+		sent:  make(map[string]time.Time),
+	}
+}
+
+func (a *alerter) alert(key, message string) {
+	if time.Since(a.sent[key]) < a.every {
+		return
+	}
+	a.sent[key] = time.Now()
+	fmt.Printf("ALERT: %v\n", message)
+	if a.url == "" {
+		return
+	}
+	go func() {
+		client := http.Client{Timeout: time.Second * 10}
+		resp, err := client.Post(a.url, "text/plain", strings.NewReader(message))
+		if err != nil {
+			fmt.Printf("Could not send alert: %v\n", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			fmt.Printf("Could not send alert: %v\n", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gocv.io/x/gocv"
+)
+
+// artifact is one reward as read off the reward dialog
+type artifact struct {
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`
+	Tier   int       `json:"tier"` // 0 if it couldn't be read
+	Rarity string    `json:"rarity"`
+}
+
+func (a artifact) String() string {
+	tier := "tier ?"
+	if a.Tier > 0 {
+		tier = fmt.Sprintf("tier %v", a.Tier)
+	}
+	return fmt.Sprintf("%v (%v, %v)", a.Name, tier, a.Rarity)
+}
+
+// Where the reward dialog shows the artifact name, and its tier and rarity
+// below it, as fractions of the 1080x1920 layout
+var (
+	artifactname   = ocrregion{name: "artifact_name", area: [4]float64{0.15, 0.36, 0.85, 0.40}}
+	artifactdetail = ocrregion{name: "artifact_detail", area: [4]float64{0.15, 0.40, 0.85, 0.43}}
+)
+
+// parseartifact makes sense of the reward dialog text. The detail line reads
+// like "Tier 3 Rare" or "T2", common artifacts have no rarity
+func parseartifact(name, detail string) artifact {
+	a := artifact{Name: strings.TrimSpace(name), Rarity: "common"}
+	if a.Name == "" {
+		a.Name = "unknown"
+	}
+	lower := strings.ToLower(detail)
+	for _, rarity := range []string{"legendary", "epic", "rare"} {
+		if strings.Contains(lower, rarity) {
+			a.Rarity = rarity
+			break
+		}
+	}
+	if i := strings.IndexFunc(lower, unicode.IsDigit); i != -1 {
+		end := i
+		for end < len(lower) && unicode.IsDigit(rune(lower[end])) {
+			end++
+		}
+		a.Tier, _ = strconv.Atoi(lower[i:end])
+	}
+	return a
+}
+
+// inventory is a tally of the artifacts collected, kept in a file so
+// mission yield can be followed over time. Without a file it only logs
+type inventory struct {
+	filename string
+	Counts   map[string]int `json:"counts"`
+	Rewards  []artifact     `json:"rewards"`
+}
+
+func loadinventory(filename string) (*inventory, error) {
+	inv := &inventory{filename: filename, Counts: make(map[string]int)}
+	if filename == "" {
+		return inv, nil
+	}
+	if _, err := loadjson(filename, inv); err != nil {
+		return nil, err
+	}
+	if inv.Counts == nil {
+		inv.Counts = make(map[string]int)
+	}
+	return inv, nil
+}
+
+// read is what the reward dialog on screen shows
+func (inv *inventory) read(s *session, mat gocv.Mat) artifact {
+	a := artifact{Time: time.Now(), Name: "unknown", Rarity: "common"}
+	if !s.reader.enabled() {
+		return a
+	}
+	name, _ := s.reader.read(mat, artifactname.rect(mat))
+	detail, _ := s.reader.read(mat, artifactdetail.rect(mat))
+	a = parseartifact(name, detail)
+	a.Time = time.Now()
+	return a
+}
+
+// add counts an artifact and saves the tally
+func (inv *inventory) add(a artifact) {
+	key := a.String()
+	inv.Counts[key]++
+	fmt.Printf("Got artifact %v, %v of those so far\n", key, inv.Counts[key])
+	if inv.filename == "" {
+		return
+	}
+	inv.Rewards = append(inv.Rewards, a)
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err == nil {
+		err = os.WriteFile(inv.filename, data, 0644)
+	}
+	if err != nil {
+		fmt.Printf("Could not save artifact inventory: %v\n", err)
+	}
+}
+
+// collect reads and tallies the artifact on a reward dialog and clicks it
+// away. It's false if there was no reward dialog
+func (inv *inventory) collect(s *session) bool {
+	mat := s.capture()
+	button, found := s.find(mat, "collect_artifact_reward_button")
+	if !found {
+		mat.Close()
+		return false
+	}
+	a := inv.read(s, mat)
+	mat.Close()
+	inv.add(a)
+	s.click(center(button), 1)
+	time.Sleep(time.Second)
+	return true
+}
+
+// summary is the tally, most collected first
+func (inv *inventory) summary() string {
+	keys := make([]string, 0, len(inv.Counts))
+	for key := range inv.Counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if inv.Counts[keys[i]] != inv.Counts[keys[j]] {
+			return inv.Counts[keys[i]] > inv.Counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "  %v: %v\n", key, inv.Counts[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestParseArtifact(t *testing.T) {
+	tests := []struct {
+		name, detail string
+		want         artifact
+	}{
+		{name: "Book of Basan", detail: "Tier 3 Rare", want: artifact{Name: "Book of Basan", Tier: 3, Rarity: "rare"}},
+		{name: " Lunar Totem ", detail: "T2", want: artifact{Name: "Lunar Totem", Tier: 2, Rarity: "common"}},
+		{name: "Puzzle Cube", detail: "EPIC T1", want: artifact{Name: "Puzzle Cube", Tier: 1, Rarity: "epic"}},
+		{name: "", detail: "Tier 4 Rare", want: artifact{Name: "unknown", Tier: 4, Rarity: "rare"}},
+		{name: "Vial of Martian Dust", detail: "", want: artifact{Name: "Vial of Martian Dust", Rarity: "common"}},
+	}
+	for _, test := range tests {
+		if got := parseartifact(test.name, test.detail); got != test.want {
+			t.Errorf("parseartifact(%q, %q) = %+v, want %+v", test.name, test.detail, got, test.want)
+		}
+	}
+}
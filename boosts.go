@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"time"
+)
+
+// boostplan is a set of boosts to use together. Boosts are found in the
+// boosts dialog by their template boost_<name>, and seen running on the
+// farm by boost_active_<name> if there is such a template
+type boostplan struct {
+	Name       string   `json:"name"`
+	Boosts     []string `json:"boosts"`
+	Conditions []string `json:"when"`     // as in the rules, checked on the farm
+	Duration   duration `json:"duration"` // how long the boosts last once used
+	Every      duration `json:"every"`    // at most this often, on top of the duration
+
+	conditions []condition
+	used       time.Time
+}
+
+type boostmanager struct {
+	Plans     []*boostplan `json:"plans"`
+	UseButton string       `json:"use_button"` // on each boost's row in the dialog
+
+	allowed map[string]bool // plans the farm profile allows, nil for all
+}
+
+var defaultboosts = boostmanager{
+	UseButton: "boost_use_button",
+}
+
+// defaultboostplans are the plans the contract farm profile picks. They're
+// made fresh for each load, so the parsed conditions aren't shared
+func defaultboostplans() []*boostplan {
+	return []*boostplan{
+		{
+			Name:       "contract tachyon and beacons",
+			Boosts:     []string{"tachyon prism", "boost beacon"},
+			Conditions: []string{"flag contract", "not flag habs_full"},
+			Duration:   duration(time.Minute * 10),
+			Every:      duration(time.Minute * 20),
+		},
+		{
+			Name:       "contract soul mirror",
+			Boosts:     []string{"soul mirror"},
+			Conditions: []string{"flag contract"},
+			Duration:   duration(time.Hour),
+		},
+	}
+}
+
+func loadboosts(filename string) (*boostmanager, error) {
+	bm := defaultboosts
+	found, err := loadjson(filename, &bm)
+	if err != nil {
+		return nil, err
+	}
+	// A file has only its own plans, none if it doesn't list any
+	if !found {
+		bm.Plans = defaultboostplans()
+	}
+	for _, plan := range bm.Plans {
+		for _, text := range plan.Conditions {
+			c, err := parsecondition(text)
+			if err != nil {
+				return nil, fmt.Errorf("boost plan %v: %v", plan.Name, err)
+			}
+			plan.conditions = append(plan.conditions, c)
+		}
+	}
+	return &bm, nil
+}
+
+// active is whether any of the plan's boosts are still running
+func (bm *boostmanager) active(plan *boostplan, ctx *rulecontext) bool {
+	if ctx.now.Sub(plan.used) < time.Duration(plan.Duration) {
+		return true
+	}
+	for _, boost := range plan.Boosts {
+		if _, seen := ctx.seen[templatename("boost_active_", boost)]; seen {
+			return true
+		}
+	}
+	return false
+}
+
+// due is the first plan that should be used now, or nil
+func (bm *boostmanager) due(ctx *rulecontext, engine *ruleengine) *boostplan {
+	for _, plan := range bm.Plans {
+		if bm.allowed != nil && !bm.allowed[plan.Name] {
+			continue
+		}
+		if bm.active(plan, ctx) || ctx.now.Sub(plan.used) < time.Duration(plan.Duration)+time.Duration(plan.Every) {
+			continue
+		}
+		holds := true
+		for _, c := range plan.conditions {
+			if ok, _ := engine.holds(c, ctx); !ok {
+				holds = false
+				break
+			}
+		}
+		if holds {
+			return plan
+		}
+	}
+	return nil
+}
+
+// use opens the boosts dialog and uses every boost in the plan, clicking the
+// use button on the same row as the boost
+func (bm *boostmanager) use(s *session, plan *boostplan) {
+	// Whatever happens, don't try again right away
+	plan.used = time.Now()
+	if !s.clickon("boosts_button", time.Second*2) {
+		fmt.Println("Can't find the boosts button")
+		return
+	}
+	defer s.leave()
+
+	for _, boost := range plan.Boosts {
+		mat := s.capture()
+		icon, found := s.find(mat, templatename("boost_", boost))
+		buttons := s.findall(mat, bm.UseButton, 10)
+		mat.Close()
+		if !found {
+			fmt.Printf("No %v boost in the boosts dialog\n", boost)
+			continue
+		}
+
+		row := center(icon)
+		var button image.Point
+		found = false
+		for _, b := range buttons {
+			if abs(center(b).Y-row.Y) < icon.Dy() {
+				button, found = center(b), true
+				break
+			}
+		}
+		if !found {
+			fmt.Printf("Can't find the use button for %v\n", boost)
+			continue
+		}
+		s.click(button, 1)
+		time.Sleep(time.Second)
+		// Some boosts ask first
+		s.clickon("ok", time.Second)
+		fmt.Printf("Used %v boost (%v)\n", boost, plan.Name)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// loadjson reads filename into v and reports whether it was there. A file
+// that doesn't exist leaves v as it is
+func loadjson(filename string, v any) (bool, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("parsing %v: %v", filename, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+)
+
+// farmprofile is how the bot behaves on one type of farm
+type farmprofile struct {
+	DisableRules []string `json:"disable_rules"` // rules that don't run on this farm
+	BoostPlans   []string `json:"boost_plans"`   // plans that may be used, all if left out
+	HatchHold    duration `json:"hatch_hold"`    // longest press on the chicken button, 0 keeps the default
+	HatchMinFill float64  `json:"hatch_min_fill"`
+}
+
+// farmprofiles are the profiles for the home farm and contract farms. Which
+// farm is active is told by contract_indicator, only shown on contract farms
+type farmprofiles struct {
+	Profiles map[string]farmprofile `json:"profiles"`
+
+	farm      string // home or contract, blank until the farm has been seen
+	disabled  []string
+	goals     []float64 // of the contract being played, lowest first
+	delivered float64
+}
+
+var defaultfarmprofiles = farmprofiles{
+	Profiles: map[string]farmprofile{
+		"home": {
+			DisableRules: []string{"collect contract reward"},
+		},
+		// Contracts run against the clock, so hatch harder and don't leave the farm for long
+		"contract": {
+			DisableRules: []string{"prestige", "research"},
+			BoostPlans:   []string{"contract tachyon and beacons", "contract soul mirror"},
+			HatchHold:    duration(time.Second * 15),
+			HatchMinFill: 0.05,
+		},
+	},
+}
+
+func loadfarmprofiles(filename string) (*farmprofiles, error) {
+	fp := defaultfarmprofiles
+	fp.Profiles = nil
+	found, err := loadjson(filename, &fp)
+	if err != nil {
+		return nil, err
+	}
+	// A file has only its own profiles
+	if !found {
+		fp.Profiles = defaultfarmprofiles.Profiles
+	}
+	return &fp, nil
+}
+
+// farmtype is which farm the detections on the farm screen are from
+func farmtype(seen map[string]image.Point) string {
+	if _, found := seen["contract_indicator"]; found {
+		return "contract"
+	}
+	return "home"
+}
+
+// switchto applies the profile for a farm type when it changes
+func (fp *farmprofiles) switchto(farm string, engine *ruleengine, boosts *boostmanager, hatch *hatchery) {
+	if farm == fp.farm {
+		return
+	}
+	fmt.Printf("On a %v farm now\n", farm)
+	// Only turn back on what this did, not rules scripts turned off
+	for _, name := range fp.disabled {
+		if engine.disabled[name] == fp.farm+" farm profile" {
+			engine.enable(name)
+		}
+	}
+	fp.farm = farm
+	fp.goals, fp.delivered = nil, 0
+	profile := fp.Profiles[farm]
+
+	fp.disabled = nil
+	for _, name := range profile.DisableRules {
+		if err := engine.disable(name, farm+" farm profile"); err != nil {
+			fmt.Printf("Farm profile %v: %v\n", farm, err)
+			continue
+		}
+		fp.disabled = append(fp.disabled, name)
+	}
+
+	boosts.allowed = nil
+	if profile.BoostPlans != nil {
+		boosts.allowed = make(map[string]bool)
+		for _, name := range profile.BoostPlans {
+			boosts.allowed[name] = true
+		}
+	}
+
+	fresh := newhatchery()
+	hatch.maxhold, hatch.minfill = fresh.maxhold, fresh.minfill
+	if profile.HatchHold > 0 {
+		hatch.maxhold = time.Duration(profile.HatchHold)
+	}
+	if profile.HatchMinFill > 0 {
+		hatch.minfill = profile.HatchMinFill
+	}
+}
+
+// addgoal keeps a goal amount of the contract, unless it's known already
+func (fp *farmprofiles) addgoal(goal float64) {
+	for i, known := range fp.goals {
+		// Readings only have about 3 significant digits
+		if math.Abs(known-goal) <= goal*0.01 {
+			return
+		}
+		if goal < known {
+			fp.goals = append(fp.goals[:i], append([]float64{goal}, fp.goals[i:]...)...)
+			return
+		}
+	}
+	fp.goals = append(fp.goals, goal)
+}
+
+// goalreadings learns the contract's goal tiers, from the goal list when it's
+// open and from the goal the progress bar is heading for, and adds
+// contract_goals_done and contract_next_goal to the readings
+func (fp *farmprofiles) goalreadings(readings map[string]reading) {
+	if fp.farm != "contract" {
+		return
+	}
+	for i := 1; i <= 3; i++ {
+		if rd, found := readings[fmt.Sprintf("contract_goal_%d", i)]; found && rd.value > 0 {
+			fp.addgoal(rd.value)
+		}
+	}
+	progress, found := readings["contract_progress"]
+	if !found {
+		return
+	}
+	delivered, goal, err := splitprogress(progress.text)
+	if err != nil {
+		return
+	}
+	// Much less delivered than before is the next contract
+	if delivered < fp.delivered/2 {
+		fp.goals = nil
+	}
+	fp.delivered = delivered
+	fp.addgoal(goal)
+
+	done := 0
+	next := goal
+	for _, g := range fp.goals {
+		if delivered >= g {
+			done++
+		} else {
+			next = g
+			break
+		}
+	}
+	readings["contract_goals_done"] = reading{text: fmt.Sprint(done), value: float64(done), confidence: progress.confidence, time: progress.time}
+	readings["contract_next_goal"] = reading{text: fmt.Sprint(next), value: next, confidence: progress.confidence, time: progress.time}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestGoalReadings(t *testing.T) {
+	fp := &farmprofiles{farm: "contract"}
+	progress := func(text string) map[string]reading {
+		return map[string]reading{"contract_progress": {text: text, confidence: 1}}
+	}
+
+	// Goals are learned from the bar as they come up
+	readings := progress("1q/5q")
+	fp.goalreadings(readings)
+	if done, next := readings["contract_goals_done"].value, readings["contract_next_goal"].value; done != 0 || next != 5e15 {
+		t.Errorf("before the first goal: %v done, next %v", done, next)
+	}
+
+	// The goal list fills in the rest, a rounded reading of a known goal isn't a new one
+	readings = progress("2q/5q")
+	readings["contract_goal_1"] = reading{value: 5.01e15}
+	readings["contract_goal_2"] = reading{value: 20e15}
+	readings["contract_goal_3"] = reading{value: 100e15}
+	fp.goalreadings(readings)
+	if len(fp.goals) != 3 {
+		t.Fatalf("goals %v, want 3 of them", fp.goals)
+	}
+
+	readings = progress("25q/100q")
+	fp.goalreadings(readings)
+	if done, next := readings["contract_goals_done"].value, readings["contract_next_goal"].value; done != 2 || next != 100e15 {
+		t.Errorf("past two goals: %v done, next %v", done, next)
+	}
+
+	// Much less delivered is the next contract, with goals of its own
+	readings = progress("1T/2q")
+	fp.goalreadings(readings)
+	if len(fp.goals) != 1 || fp.goals[0] != 2e15 {
+		t.Errorf("goals %v after the contract changed, want just 2q", fp.goals)
+	}
+
+	// Nothing is read on the home farm
+	home := &farmprofiles{farm: "home"}
+	readings = progress("1q/5q")
+	home.goalreadings(readings)
+	if _, found := readings["contract_goals_done"]; found {
+		t.Error("goals read on the home farm")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"time"
+	_ "time/tzdata" // Windows doesn't always have the zone database
+
+	"gocv.io/x/gocv"
+)
+
+// dailyoffer is something the game hands out once a day: opened by clicking
+// its template on the farm, optionally doubled by a video, then collected
+type dailyoffer struct {
+	Name    string     `json:"name"`
+	Open    string     `json:"open"`    // template on the farm
+	Video   string     `json:"video"`   // button that watches a video for more, blank if there's none
+	Collect string     `json:"collect"` // template or group that takes the reward
+	Reward  [4]float64 `json:"reward"`  // where the dialog says what's given, fractions of the screen
+}
+
+// dailyconfig knows when the game's day starts over
+type dailyconfig struct {
+	Offers []dailyoffer `json:"offers"`
+	Reset  string       `json:"reset"` // time of day the game resets, "15:04"
+	Zone   string       `json:"zone"`  // time zone the reset time is in
+	Log    string       `json:"log"`   // file what was collected is kept in
+
+	location *time.Location
+	resetat  time.Duration // after midnight
+	state    dailystate
+	pending  *dailypending // offer whose video is playing, collected after it
+}
+
+// dailypending is an offer waiting for its video to finish
+type dailypending struct {
+	offer  *dailyoffer
+	reward dailyreward
+}
+
+type dailyreward struct {
+	Time   time.Time `json:"time"`
+	Offer  string    `json:"offer"`
+	Reward string    `json:"reward"` // as read, blank if it couldn't be
+	Video  bool      `json:"video"`
+}
+
+// dailystate is kept across restarts
+type dailystate struct {
+	Collected map[string]time.Time `json:"collected"` // last time each offer was collected
+	Rewards   []dailyreward        `json:"rewards"`
+}
+
+var defaultdaily = dailyconfig{
+	Offers: []dailyoffer{
+		{Name: "daily gift", Open: "daily_reward", Video: "daily_video_button", Collect: "collect", Reward: [4]float64{0.15, 0.45, 0.85, 0.50}},
+		{Name: "timed video", Open: "timed_video_offer", Video: "timed_video_button", Collect: "collect", Reward: [4]float64{0.15, 0.45, 0.85, 0.50}},
+		{Name: "timed gift", Open: "timed_gift_offer", Collect: "collect", Reward: [4]float64{0.15, 0.45, 0.85, 0.50}},
+	},
+	Reset: "00:00",
+	Zone:  "America/Los_Angeles",
+	Log:   "daily_rewards.json",
+}
+
+func loaddaily(filename string) (*dailyconfig, error) {
+	dc := defaultdaily
+	// Offers in the file replace the built in ones, which mustn't be written over
+	dc.Offers = append([]dailyoffer(nil), defaultdaily.Offers...)
+	if _, err := loadjson(filename, &dc); err != nil {
+		return nil, err
+	}
+
+	location, err := time.LoadLocation(dc.Zone)
+	if err != nil {
+		return nil, fmt.Errorf("daily reset zone: %v", err)
+	}
+	dc.location = location
+	reset, err := time.Parse("15:04", dc.Reset)
+	if err != nil {
+		return nil, fmt.Errorf("daily reset time: %v", err)
+	}
+	dc.resetat = time.Duration(reset.Hour())*time.Hour + time.Duration(reset.Minute())*time.Minute
+
+	dc.state = dailystate{Collected: make(map[string]time.Time)}
+	if _, err := loadjson(dc.Log, &dc.state); err != nil {
+		return nil, err
+	}
+	if dc.state.Collected == nil {
+		dc.state.Collected = make(map[string]time.Time)
+	}
+	return &dc, nil
+}
+
+// lastreset is when the game's day last started over
+func (dc *dailyconfig) lastreset(now time.Time) time.Time {
+	local := now.In(dc.location)
+	// By the clock, so a day that's longer or shorter for daylight saving doesn't move it
+	reset := time.Date(local.Year(), local.Month(), local.Day(), int(dc.resetat/time.Hour), int(dc.resetat%time.Hour/time.Minute), 0, 0, dc.location)
+	if reset.After(local) {
+		reset = reset.AddDate(0, 0, -1)
+	}
+	return reset
+}
+
+// due is the first offer on screen that hasn't been collected since the reset, or nil
+func (dc *dailyconfig) due(seen map[string]image.Point, now time.Time) *dailyoffer {
+	reset := dc.lastreset(now)
+	for i := range dc.Offers {
+		offer := &dc.Offers[i]
+		if _, found := seen[offer.Open]; found && dc.state.Collected[offer.Name].Before(reset) {
+			return offer
+		}
+	}
+	return nil
+}
+
+// record keeps what an offer gave, and that it's done until the next reset
+func (dc *dailyconfig) record(r dailyreward) {
+	dc.state.Collected[r.Offer] = r.Time
+	dc.state.Rewards = append(dc.state.Rewards, r)
+	fmt.Printf("Collected %v: %v (video: %v)\n", r.Offer, r.Reward, r.Video)
+
+	data, err := json.MarshalIndent(dc.state, "", "  ")
+	if err == nil {
+		err = os.WriteFile(dc.Log, data, 0644)
+	}
+	if err != nil {
+		fmt.Printf("Could not save daily rewards: %v\n", err)
+	}
+}
+
+// take clicks the collect button until the reward dialog is gone, and only
+// then records the reward
+func (dc *dailyconfig) take(s *session, offer *dailyoffer, reward dailyreward) {
+	for try := 0; try < 3; try++ {
+		if !s.clickon(offer.Collect, time.Second) {
+			dc.record(reward)
+			return
+		}
+	}
+	fmt.Printf("The %v dialog won't go away\n", offer.Name)
+	s.leave()
+}
+
+// readreward is what the reward dialog on screen says is given
+func (dc *dailyconfig) readreward(s *session, mat gocv.Mat, offer *dailyoffer) string {
+	if !s.reader.enabled() {
+		return ""
+	}
+	text, _ := s.reader.read(mat, ocrregion{area: offer.Reward}.rect(mat))
+	return text
+}
+
+// rewarddialog is true when the reward dialog of the offer whose video was
+// watched is on screen
+func (dc *dailyconfig) rewarddialog(seen map[string]image.Point) bool {
+	if dc.pending == nil {
+		return false
+	}
+	_, found := seen[dc.pending.offer.Collect]
+	return found
+}
+
+// aftervideo collects the reward dialog that shows up after an offer's video
+func (dc *dailyconfig) aftervideo(s *session) {
+	pending := dc.pending
+	dc.pending = nil
+	mat := s.capture()
+	_, found := s.find(mat, pending.offer.Collect)
+	if found {
+		if text := dc.readreward(s, mat, pending.offer); text != "" {
+			pending.reward.Reward = text
+		}
+	}
+	mat.Close()
+	if !found {
+		fmt.Printf("No reward dialog after the %v video, trying again later\n", pending.offer.Name)
+		return
+	}
+	pending.reward.Time = time.Now()
+	dc.take(s, pending.offer, pending.reward)
+}
+
+// collect opens the offer that's due and takes the reward, watching the video
+// for more if the ad policy allows it. The offer only counts as collected
+// when its reward dialog, after the video if there was one, is gone. It's
+// true if a video was started
+func (dc *dailyconfig) collect(s *session, ads *adpolicy) bool {
+	if dc.pending != nil {
+		dc.aftervideo(s)
+		return false
+	}
+
+	mat := s.capture()
+	seen := make(map[string]image.Point)
+	for i := range dc.Offers {
+		if r, found := s.find(mat, dc.Offers[i].Open); found {
+			seen[dc.Offers[i].Open] = center(r)
+		}
+	}
+	mat.Close()
+	offer := dc.due(seen, time.Now())
+	if offer == nil {
+		return false
+	}
+	s.click(seen[offer.Open], 1)
+	time.Sleep(time.Second * 2)
+
+	mat = s.capture()
+	reward := dailyreward{Time: time.Now(), Offer: offer.Name}
+	reward.Reward = dc.readreward(s, mat, offer)
+	video, hasvideo := image.Rectangle{}, false
+	if offer.Video != "" {
+		video, hasvideo = s.find(mat, offer.Video)
+	}
+	_, hascollect := s.find(mat, offer.Collect)
+	mat.Close()
+
+	if hasvideo {
+		watch, why := ads.decide(offer.Video, time.Now())
+		if watch {
+			fmt.Printf("Watching the %v video, %v\n", offer.Name, why)
+			s.click(center(video), 1)
+			ads.started(offer.Video)
+			reward.Video = true
+			dc.pending = &dailypending{offer: offer, reward: reward}
+			return true
+		}
+		fmt.Printf("Not watching the %v video, %v\n", offer.Name, why)
+	}
+
+	if !hascollect {
+		fmt.Printf("No %v in the %v dialog, trying again later\n", offer.Collect, offer.Name)
+		s.leave()
+		return false
+	}
+	dc.take(s, offer, reward)
+	return false
+}
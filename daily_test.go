@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastReset(t *testing.T) {
+	zone, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, zone)
+	}
+	tests := []struct {
+		reset time.Duration
+		now   time.Time
+		want  time.Time
+	}{
+		// Before and at the reset time
+		{reset: 9 * time.Hour, now: at(2026, 10, 19, 8, 59), want: at(2026, 10, 18, 9, 0)},
+		{reset: 9 * time.Hour, now: at(2026, 10, 19, 9, 0), want: at(2026, 10, 19, 9, 0)},
+		// Another day in UTC than in the reset's zone
+		{reset: 9 * time.Hour, now: time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC), want: at(2026, 10, 18, 9, 0)},
+		// Midnight, and late in the evening across the year and month end
+		{reset: 0, now: at(2026, 1, 1, 0, 30), want: at(2026, 1, 1, 0, 0)},
+		{reset: 0, now: at(2025, 12, 31, 23, 59), want: at(2025, 12, 31, 0, 0)},
+		{reset: 23*time.Hour + 30*time.Minute, now: at(2026, 3, 1, 0, 15), want: at(2026, 2, 28, 23, 30)},
+		// Days when daylight saving starts and ends
+		{reset: 9 * time.Hour, now: at(2026, 3, 8, 10, 0), want: at(2026, 3, 8, 9, 0)},
+		{reset: 9 * time.Hour, now: at(2026, 11, 1, 9, 30), want: at(2026, 11, 1, 9, 0)},
+		{reset: 9 * time.Hour, now: at(2026, 3, 9, 8, 0), want: at(2026, 3, 8, 9, 0)},
+	}
+	for _, test := range tests {
+		dc := dailyconfig{location: zone, resetat: test.reset}
+		if got := dc.lastreset(test.now); !got.Equal(test.want) {
+			t.Errorf("reset at %v, lastreset(%v) = %v, want %v", test.reset, test.now, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// colorrange is an inclusive range of BGR values, the order the captured frames are in
+type colorrange struct {
+	Min [3]float64 `json:"min"`
+	Max [3]float64 `json:"max"`
+}
+
+func (cr colorrange) scalars() (gocv.Scalar, gocv.Scalar) {
+	return gocv.NewScalar(cr.Min[0], cr.Min[1], cr.Min[2], 0), gocv.NewScalar(cr.Max[0], cr.Max[1], cr.Max[2], 0)
+}
+
+// droneprofile describes what a drone looks like. A drone is a black blob
+// overlapping a brown blob, both with bounding box areas within limits,
+// inside a vertical band of the screen
+type droneprofile struct {
+	Black     colorrange `json:"black"`
+	Brown     colorrange `json:"brown"`
+	BlackArea [2]int     `json:"black_area"`
+	BrownArea [2]int     `json:"brown_area"`
+
+	// Colour of the reward popping up where a drone was shot down
+	Reward colorrange `json:"reward"`
+
+	// Elite drones have some of this colour on them, covering at least
+	// EliteFraction of the drone, or fly faster than EliteSpeed pixels per second
+	Elite         colorrange `json:"elite"`
+	EliteFraction float64    `json:"elite_fraction"`
+	EliteSpeed    float64    `json:"elite_speed"`
+
+	// Fractions of the screen height
+	BandTop    float64 `json:"band_top"`
+	BandBottom float64 `json:"band_bottom"`
+
+	// Screen height the areas were measured at
+	Height int `json:"height"`
+
+	// Places drones are never looked for or shot at
+	Masks []dronemask `json:"masks"`
+}
+
+// dronemask is a polygon in fractions of the screen size. It is active
+// always, when a dialog is open ("dialog") or on the named screen
+type dronemask struct {
+	Name    string       `json:"name"`
+	Polygon [][2]float64 `json:"polygon"`
+	When    string       `json:"when,omitempty"`
+}
+
+func (dm dronemask) active(screen gamescreen) bool {
+	switch dm.When {
+	case "", "always":
+		return true
+	case "dialog":
+		return screen.isdialog()
+	}
+	when, found := parsegamescreen(dm.When)
+	return found && when == screen
+}
+
+// points is the polygon on a screen of the given size
+func (dm dronemask) points(size image.Point) []image.Point {
+	points := make([]image.Point, len(dm.Polygon))
+	for i, p := range dm.Polygon {
+		points[i] = image.Pt(int(p[0]*float64(size.X)), int(p[1]*float64(size.Y)))
+	}
+	return points
+}
+
+// contains is true if p, in fractions of the screen, is inside the polygon
+func (dm dronemask) contains(x, y float64) bool {
+	inside := false
+	n := len(dm.Polygon)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		xi, yi := dm.Polygon[i][0], dm.Polygon[i][1]
+		xj, yj := dm.Polygon[j][0], dm.Polygon[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// masked is true if p on a screen of the given size is inside an active mask
+func (dp droneprofile) masked(p image.Point, size image.Point, screen gamescreen) bool {
+	x := float64(p.X) / float64(size.X)
+	y := float64(p.Y) / float64(size.Y)
+	for _, dm := range dp.Masks {
+		if dm.active(screen) && dm.contains(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+func rectmask(name, when string, minx, miny, maxx, maxy float64) dronemask {
+	return dronemask{
+		Name:    name,
+		Polygon: [][2]float64{{minx, miny}, {maxx, miny}, {maxx, maxy}, {minx, maxy}},
+		When:    when,
+	}
+}
+
+var defaultdroneprofile = droneprofile{
+	Black:     colorrange{Min: [3]float64{30, 30, 30}, Max: [3]float64{50, 50, 50}},
+	Brown:     colorrange{Min: [3]float64{70, 142, 183}, Max: [3]float64{90, 162, 203}},
+	BlackArea: [2]int{80, 400},
+	BrownArea: [2]int{25, 300},
+	Reward:    colorrange{Min: [3]float64{0, 170, 200}, Max: [3]float64{110, 255, 255}},
+
+	Elite:         colorrange{Min: [3]float64{140, 40, 120}, Max: [3]float64{255, 120, 255}},
+	EliteFraction: 0.05,
+	EliteSpeed:    700,
+
+	Masks: []dronemask{
+		rectmask("top hud", "", 0, 0, 1, 0.11),
+		rectmask("hatchery button", "", 0.33, 0.80, 0.67, 1),
+		rectmask("ad and gift column", "", 0.86, 0.12, 1, 0.62),
+	},
+	BandTop:    0.11,
+	BandBottom: 0.88,
+	Height:     960,
+}
+
+// loaddroneprofile reads the profile, or returns the built in one if there is no file
+func loaddroneprofile(filename string) (droneprofile, error) {
+	dp := defaultdroneprofile
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return dp, nil
+	}
+	if err != nil {
+		return dp, err
+	}
+	if err = json.Unmarshal(data, &dp); err != nil {
+		return dp, fmt.Errorf("parsing %v: %v", filename, err)
+	}
+	return dp, nil
+}
+
+func (dp droneprofile) save(filename string) error {
+	data, err := json.MarshalIndent(dp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// scaled returns the profile with areas converted to a screen of the given height
+func (dp droneprofile) scaled(height int) droneprofile {
+	if dp.Height == 0 || dp.Height == height {
+		return dp
+	}
+	f := float64(height) / float64(dp.Height)
+	for i := range dp.BlackArea {
+		dp.BlackArea[i] = int(float64(dp.BlackArea[i]) * f * f)
+		dp.BrownArea[i] = int(float64(dp.BrownArea[i]) * f * f)
+	}
+	dp.EliteSpeed *= f
+	dp.Height = height
+	return dp
+}
+
+// Labelled drones are kept in labels.json next to the frames, as
+// {"frame.png": [[minx, miny, maxx, maxy], ...]}
+type dronelabels map[string][][4]int
+
+func loaddronelabels(filename string) (dronelabels, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var labels dronelabels
+	if err = json.Unmarshal(data, &labels); err != nil {
+		return nil, fmt.Errorf("parsing %v: %v", filename, err)
+	}
+	return labels, nil
+}
+
+func (dl dronelabels) save(filename string) error {
+	data, err := json.MarshalIndent(dl, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+func labelrect(box [4]int) image.Rectangle {
+	return image.Rect(box[0], box[1], box[2], box[3])
+}
+
+// percentile of an already sorted slice
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+// calibratedrones derives a profile from recorded frames with labelled drone
+// boxes. Inside each box, dark grey pixels are the drone body and warm
+// pixels the brown part; colour ranges are the 2-98 percentiles of those, and
+// the area limits and band come from running the new ranges over the boxes.
+// Everything else, like masks and elite drones, is kept from base
+func calibratedrones(base droneprofile, dir string, scaley int, kernel gocv.Mat) (droneprofile, error) {
+	dp := base.scaled(scaley)
+	dp.Height = scaley
+
+	labels, err := loaddronelabels(filepath.Join(dir, "labels.json"))
+	if err != nil {
+		return dp, err
+	}
+
+	type framebox struct {
+		frame gocv.Mat
+		box   image.Rectangle
+	}
+	var boxes []framebox
+	var black, brown [3][]float64
+
+	for filename, frameboxes := range labels {
+		frame := gocv.IMRead(filepath.Join(dir, filename), gocv.IMReadColor)
+		if frame.Empty() {
+			return dp, fmt.Errorf("could not read frame %v", filename)
+		}
+		factor := 1.0
+		if frame.Rows() != scaley {
+			factor = float64(scaley) / float64(frame.Rows())
+			gocv.Resize(frame, &frame, image.Point{}, factor, factor, gocv.InterpolationLanczos4)
+		}
+		defer frame.Close()
+
+		for _, fb := range frameboxes {
+			box := labelrect(fb)
+			box = image.Rect(int(float64(box.Min.X)*factor), int(float64(box.Min.Y)*factor),
+				int(float64(box.Max.X)*factor), int(float64(box.Max.Y)*factor))
+			box = box.Intersect(image.Rect(0, 0, frame.Cols(), frame.Rows()))
+			if box.Empty() {
+				continue
+			}
+			boxes = append(boxes, framebox{frame, box})
+
+			for y := box.Min.Y; y < box.Max.Y; y++ {
+				for x := box.Min.X; x < box.Max.X; x++ {
+					v := frame.GetVecbAt(y, x)
+					b, g, r := float64(v[0]), float64(v[1]), float64(v[2])
+					lo, hi := b, b
+					for _, c := range []float64{g, r} {
+						if c < lo {
+							lo = c
+						}
+						if c > hi {
+							hi = c
+						}
+					}
+					switch {
+					case hi < 90 && hi-lo < 25:
+						for c := 0; c < 3; c++ {
+							black[c] = append(black[c], float64(v[c]))
+						}
+					case r > g && g > b && r-b > 60:
+						for c := 0; c < 3; c++ {
+							brown[c] = append(brown[c], float64(v[c]))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(boxes) == 0 || len(black[0]) == 0 || len(brown[0]) == 0 {
+		return dp, fmt.Errorf("not enough labelled drone pixels in %v", dir)
+	}
+
+	for c := 0; c < 3; c++ {
+		sort.Float64s(black[c])
+		sort.Float64s(brown[c])
+		dp.Black.Min[c] = percentile(black[c], 0.02) - 5
+		dp.Black.Max[c] = percentile(black[c], 0.98) + 5
+		dp.Brown.Min[c] = percentile(brown[c], 0.02) - 5
+		dp.Brown.Max[c] = percentile(brown[c], 0.98) + 5
+	}
+
+	// Blob sizes as the detector will see them with the new ranges
+	largest := func(mat gocv.Mat, cr colorrange) int {
+		lb, ub := cr.scalars()
+		detect := gocv.NewMat()
+		gocv.InRangeWithScalar(mat, lb, ub, &detect)
+		dilated := gocv.NewMat()
+		gocv.Dilate(detect, &dilated, kernel)
+		detect.Close()
+		contours := gocv.FindContours(dilated, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+		dilated.Close()
+		var best int
+		for i := 0; i < contours.Size(); i++ {
+			bb := gocv.BoundingRect(contours.At(i))
+			if bb.Dx()*bb.Dy() > best {
+				best = bb.Dx() * bb.Dy()
+			}
+		}
+		contours.Close()
+		return best
+	}
+
+	dp.BlackArea = [2]int{1 << 30, 0}
+	dp.BrownArea = [2]int{1 << 30, 0}
+	dp.BandTop, dp.BandBottom = 1, 0
+	widen := func(limits *[2]int, area int) {
+		if area == 0 {
+			return
+		}
+		if area < limits[0] {
+			limits[0] = area
+		}
+		if area > limits[1] {
+			limits[1] = area
+		}
+	}
+	for _, fb := range boxes {
+		margin := fb.box.Dx() / 2
+		region := fb.frame.Region(fb.box.Inset(-margin).Intersect(image.Rect(0, 0, fb.frame.Cols(), fb.frame.Rows())))
+		widen(&dp.BlackArea, largest(region, dp.Black))
+		widen(&dp.BrownArea, largest(region, dp.Brown))
+		region.Close()
+
+		top := float64(fb.box.Min.Y) / float64(fb.frame.Rows())
+		bottom := float64(fb.box.Max.Y) / float64(fb.frame.Rows())
+		if top < dp.BandTop {
+			dp.BandTop = top
+		}
+		if bottom > dp.BandBottom {
+			dp.BandBottom = bottom
+		}
+	}
+
+	if dp.BlackArea[1] == 0 || dp.BrownArea[1] == 0 {
+		return dp, fmt.Errorf("new colour ranges don't find the labelled drones, check the labels")
+	}
+
+	// Leave some room for drones slightly smaller, larger or further out than the ones we saw
+	dp.BlackArea = [2]int{dp.BlackArea[0] * 7 / 10, dp.BlackArea[1] * 13 / 10}
+	dp.BrownArea = [2]int{dp.BrownArea[0] * 7 / 10, dp.BrownArea[1] * 13 / 10}
+	dp.BandTop = math.Max(dp.BandTop-0.02, 0)
+	dp.BandBottom = math.Min(dp.BandBottom+0.02, 1)
+
+	return dp, nil
+}
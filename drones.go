@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+type dronedetection struct {
+	position     image.Point
+	black, brown image.Rectangle
+	elitepixels  int // pixels with the elite colour inside the drone
+}
+
+func (dd dronedetection) rect() image.Rectangle {
+	return dd.black.Union(dd.brown)
+}
+
+// blobs finds the bounding boxes of areas in the colour range, that are
+// within the size limits and inside the vertical band
+func blobs(mat gocv.Mat, cr colorrange, kernel gocv.Mat, area [2]int, top, bottom int) []image.Rectangle {
+	lb, ub := cr.scalars()
+	detect := gocv.NewMat()
+	gocv.InRangeWithScalar(mat, lb, ub, &detect)
+	dilated := gocv.NewMat()
+	gocv.Dilate(detect, &dilated, kernel)
+	detect.Close()
+	contours := gocv.FindContours(dilated, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	dilated.Close()
+
+	var rects []image.Rectangle
+	for i := 0; i < contours.Size(); i++ {
+		bb := gocv.BoundingRect(contours.At(i))
+		a := bb.Dx() * bb.Dy()
+		if a > area[0] && a < area[1] && bb.Min.Y > top && bb.Max.Y < bottom {
+			rects = append(rects, bb)
+		}
+	}
+	contours.Close()
+	return rects
+}
+
+// detectdrones looks for something black and brown
+func detectdrones(mat gocv.Mat, dp droneprofile, kernel gocv.Mat) []dronedetection {
+	return detectdronesin(mat, image.Rect(0, 0, mat.Cols(), mat.Rows()), dp, kernel)
+}
+
+// detectdronesin only looks inside area of the screen
+func detectdronesin(mat gocv.Mat, area image.Rectangle, dp droneprofile, kernel gocv.Mat) []dronedetection {
+	area = area.Intersect(image.Rect(0, 0, mat.Cols(), mat.Rows()))
+	if area.Empty() {
+		return nil
+	}
+	region := mat.Region(area)
+	defer region.Close()
+	top := int(dp.BandTop*float64(mat.Rows())) - area.Min.Y
+	bottom := int(dp.BandBottom*float64(mat.Rows())) - area.Min.Y
+	blackrects := blobs(region, dp.Black, kernel, dp.BlackArea, top, bottom)
+	brownrects := blobs(region, dp.Brown, kernel, dp.BrownArea, top, bottom)
+	for i := range blackrects {
+		blackrects[i] = blackrects[i].Add(area.Min)
+	}
+	for i := range brownrects {
+		brownrects[i] = brownrects[i].Add(area.Min)
+	}
+
+	var detections []dronedetection
+	for _, blackrect := range blackrects {
+		for _, brownrect := range brownrects {
+			if blackrect.Overlaps(brownrect) {
+				dronearea := blackrect.Intersect(brownrect)
+				dd := dronedetection{
+					position: image.Point{
+						X: dronearea.Min.X + dronearea.Dx()/2,
+						Y: dronearea.Min.Y + dronearea.Dy()/2,
+					},
+					black: blackrect,
+					brown: brownrect,
+				}
+				dd.elitepixels = colorpixels(mat, dd.rect(), dp.Elite)
+				detections = append(detections, dd)
+			}
+		}
+	}
+	return detections
+}
+
+// colorpixels counts the pixels within the colour range inside rect
+func colorpixels(mat gocv.Mat, rect image.Rectangle, cr colorrange) int {
+	rect = rect.Intersect(image.Rect(0, 0, mat.Cols(), mat.Rows()))
+	if rect.Empty() {
+		return 0
+	}
+	region := mat.Region(rect)
+	lb, ub := cr.scalars()
+	detect := gocv.NewMat()
+	gocv.InRangeWithScalar(region, lb, ub, &detect)
+	count := gocv.CountNonZero(detect)
+	detect.Close()
+	region.Close()
+	return count
+}
+
+// elite is true if the drone looks like an elite one in this sighting
+func (dd dronedetection) elite(dp droneprofile, speed float64) bool {
+	area := dd.rect().Dx() * dd.rect().Dy()
+	if area > 0 && dp.EliteFraction > 0 && float64(dd.elitepixels)/float64(area) >= dp.EliteFraction {
+		return true
+	}
+	return dp.EliteSpeed > 0 && speed > dp.EliteSpeed
+}
+
+// recorder saves farm frames for drone calibration, along with what the
+// detector found in them. Copy detections.json to labels.json and fix it up
+// to get the labels calibration wants
+type recorder struct {
+	dir        string
+	interval   time.Duration
+	last       time.Time
+	detections dronelabels
+	unsaved    int
+}
+
+func newrecorder(dir string) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	r := &recorder{
+		dir:        dir,
+		interval:   time.Millisecond * 200,
+		detections: make(dronelabels),
+	}
+	if existing, err := loaddronelabels(filepath.Join(dir, "detections.json")); err == nil {
+		r.detections = existing
+	}
+	return r, nil
+}
+
+func (r *recorder) record(frame gocv.Mat, captured time.Time, detections []dronedetection) {
+	if captured.Sub(r.last) < r.interval {
+		return
+	}
+	r.last = captured
+
+	filename := fmt.Sprintf("frame_%d.png", captured.UnixNano())
+	if !gocv.IMWrite(filepath.Join(r.dir, filename), frame) {
+		fmt.Printf("Could not save %v\n", filename)
+		return
+	}
+
+	boxes := [][4]int{}
+	for _, dd := range detections {
+		rect := dd.rect()
+		boxes = append(boxes, [4]int{rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y})
+	}
+	r.detections[filename] = boxes
+
+	r.unsaved++
+	if r.unsaved >= 25 {
+		r.flush()
+	}
+}
+
+func (r *recorder) flush() {
+	if err := r.detections.save(filepath.Join(r.dir, "detections.json")); err != nil {
+		fmt.Printf("Could not save detections: %v\n", err)
+	}
+	r.unsaved = 0
+}
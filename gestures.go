@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"time"
+)
+
+// shotgesture is how a drone is clicked. A plain tap opens whatever is
+// under the drone if it misses, dragging doesn't
+type shotgesture int
+
+const (
+	gestureTap      shotgesture = iota
+	gestureWiggle               // down, drag a bit down and back up, up
+	gestureSweep                // drag along the predicted path through the aim point
+	gestureMultiTap             // taps around the aim point, along the flight path
+	gesturecount
+)
+
+var gesturenames = map[shotgesture]string{
+	gestureTap:      "tap",
+	gestureWiggle:   "wiggle",
+	gestureSweep:    "sweep",
+	gestureMultiTap: "multitap",
+}
+
+func (g shotgesture) String() string {
+	return gesturenames[g]
+}
+
+// parsegestures reads a comma separated list of gestures, "rotate" is all of them
+func parsegestures(list string) ([]shotgesture, error) {
+	if list == "rotate" {
+		var all []shotgesture
+		for g := shotgesture(0); g < gesturecount; g++ {
+			all = append(all, g)
+		}
+		return all, nil
+	}
+	var gestures []shotgesture
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for g, n := range gesturenames {
+			if n == strings.TrimSpace(name) {
+				gestures = append(gestures, g)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown shot gesture %q", name)
+		}
+	}
+	return gestures, nil
+}
+
+// gesturerotation takes turns with the gestures, so their hit rates can be compared
+type gesturerotation struct {
+	gestures []shotgesture
+	turn     int
+}
+
+// next is the gesture whose turn it is, taken only once a shot is fired with it
+func (gr *gesturerotation) next() shotgesture {
+	return gr.gestures[gr.turn%len(gr.gestures)]
+}
+
+func (gr *gesturerotation) fired() {
+	gr.turn++
+}
+
+// points is where the gesture touches the screen, in detection coordinates.
+// vx and vy is the drone velocity in pixels per second, spread how far apart
+// taps and sweep ends are. The wiggle stays within a few pixels of the aim
+func (g shotgesture) points(aim image.Point, vx, vy float64, spread int) []image.Point {
+	// Unit vector along the flight path, or across the screen for a drone standing still
+	dx, dy := 1.0, 0.0
+	if speed := math.Hypot(vx, vy); speed > 0 {
+		dx, dy = vx/speed, vy/speed
+	}
+	along := func(f float64) image.Point {
+		return aim.Add(image.Pt(int(dx*f*float64(spread)), int(dy*f*float64(spread))))
+	}
+
+	var points []image.Point
+	switch g {
+	case gestureSweep:
+		for i := -4; i <= 4; i++ {
+			points = append(points, along(float64(i)/4))
+		}
+	case gestureMultiTap:
+		for _, f := range []float64{0, 0.5, -0.5, 1} {
+			points = append(points, along(f))
+		}
+	default:
+		points = append(points, aim)
+	}
+	return points
+}
+
+// fire performs the gesture at aim, in detection coordinates, see points
+func (g shotgesture) fire(e *emulator, aim image.Point, vx, vy float64, spread int) {
+	points := g.points(aim, vx, vy, spread)
+	switch g {
+	case gestureTap:
+		e.Click(scale_pos(aim), 1)
+	case gestureWiggle:
+		p := scale_pos(aim)
+		e.MouseDown(p)
+		for i := 0; i <= 5; i++ {
+			time.Sleep(time.Millisecond * 3)
+			e.MouseDrag(p.Add(image.Pt(0, i*3)))
+		}
+		for i := 5; i >= 0; i-- {
+			time.Sleep(time.Millisecond * 3)
+			e.MouseDrag(p.Add(image.Pt(0, i*3)))
+		}
+		e.MouseUp(p)
+	case gestureSweep:
+		e.MouseDown(scale_pos(points[0]))
+		for _, p := range points {
+			time.Sleep(time.Millisecond * 3)
+			e.MouseDrag(scale_pos(p))
+		}
+		e.MouseUp(scale_pos(points[len(points)-1]))
+	case gestureMultiTap:
+		for _, p := range points {
+			e.Click(scale_pos(p), 1)
+			time.Sleep(time.Millisecond * 3)
+		}
+	}
+}
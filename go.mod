@@ -5,7 +5,8 @@ go 1.18
 require (
 	github.com/disintegration/gift v1.2.1
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e
+	go.starlark.net v0.0.0-20231101134539-556fd59b42f6
 	gocv.io/x/gocv v0.30.0
 )
 
-require golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5 // indirect
+require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// hatchery hatches chickens by holding the chicken button down, which is
+// what the game rewards with the running chicken bonus. It keeps track of
+// how full the hatchery is, and notices when the habs are full
+type hatchery struct {
+	maxhold time.Duration // longest single press
+	minfill float64       // don't start pressing with less than this in the hatchery
+	recheck time.Duration // wait this long before trying full habs again
+
+	fullgreen int       // most green seen on the button, what a full hatchery looks like
+	flat      int       // presses in a row that didn't drain the hatchery
+	habsfull  time.Time // when the habs were last found full
+}
+
+// Presses in a row that have to hatch nothing before the habs count as full
+const flatpresses = 3
+
+func newhatchery() *hatchery {
+	return &hatchery{
+		maxhold: time.Second * 8,
+		minfill: 0.1,
+		recheck: time.Minute * 5,
+	}
+}
+
+// fill is how full the hatchery is, 0-1, from how much of the chicken button is
+// green. The button drains as eggs are hatched and fills up again over time
+func (h *hatchery) fill(mat gocv.Mat, button image.Rectangle) float64 {
+	region := mat.Region(button.Intersect(image.Rect(0, 0, mat.Cols(), mat.Rows())))
+	hsv := gocv.NewMat()
+	gocv.CvtColor(region, &hsv, gocv.ColorBGRToHSV)
+	green := gocv.NewMat()
+	gocv.InRangeWithScalar(hsv, gocv.NewScalar(35, 100, 80, 0), gocv.NewScalar(85, 255, 255, 0), &green)
+	count := gocv.CountNonZero(green)
+	green.Close()
+	hsv.Close()
+	region.Close()
+
+	if count > h.fullgreen {
+		h.fullgreen = count
+	}
+	if h.fullgreen == 0 {
+		return 0
+	}
+	return float64(count) / float64(h.fullgreen)
+}
+
+// hatch holds the chicken button until the running bonus is at max, the
+// hatchery runs dry or the press has gone on long enough. If a few presses in
+// a row hatch nothing the habs are full, which raises an alert and pauses
+// hatching for a while
+func (h *hatchery) hatch(s *session, alerts *alerter) {
+	if time.Since(h.habsfull) < h.recheck {
+		return
+	}
+
+	mat := s.capture()
+	button, found := s.find(mat, "chickenbutton")
+	if !found {
+		mat.Close()
+		return
+	}
+	startfill := h.fill(mat, button)
+	mat.Close()
+	if startfill < h.minfill {
+		fmt.Printf("Hatchery is at %.0f%%, waiting for it to fill up\n", startfill*100)
+		return
+	}
+
+	p := center(button)
+	s.press(p)
+	start := time.Now()
+	fill := startfill
+	why := "held long enough"
+	for time.Since(start) < h.maxhold {
+		time.Sleep(time.Millisecond * 250)
+		mat := s.capture()
+		_, maxed := s.find(mat, "max_chicken_running_bonus")
+		fill = h.fill(mat, button)
+		mat.Close()
+		if maxed {
+			why = "running bonus is at max"
+			break
+		}
+		if fill < h.minfill/2 {
+			why = "hatchery is empty"
+			break
+		}
+	}
+	s.release(p)
+	held := time.Since(start)
+	fmt.Printf("Hatched for %v, %v (hatchery %.0f%% to %.0f%%)\n", held.Round(time.Millisecond), why, startfill*100, fill*100)
+
+	// Full habs don't take chickens, so the hatchery doesn't drain. The
+	// chicken count is no use here, it's only read to 3 digits
+	if held < time.Second*2 || why == "running bonus is at max" {
+		return
+	}
+	if startfill-fill >= 0.02 {
+		h.flat = 0
+		return
+	}
+	h.flat++
+	if h.flat >= flatpresses {
+		h.flat = 0
+		h.habsfull = time.Now()
+		alerts.alert("habs_full", "Habs are full, build or upgrade habs to keep hatching chickens")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"math"
+	"os"
+)
+
+// heatcell is what has been seen of drones in one part of the screen
+type heatcell struct {
+	Spawns int `json:"spawns"` // tracks starting here
+	Visits int `json:"visits"` // sightings of confirmed drones
+
+	// Summed velocity and squared speed of the sightings, in screen heights per second
+	VX     float64 `json:"vx"`
+	VY     float64 `json:"vy"`
+	Speed2 float64 `json:"speed2"`
+
+	Hits int `json:"hits"`
+}
+
+// heatmap learns where drones show up and how they fly there, across
+// sessions. Positions and velocities are kept as fractions of the screen
+// height, so it survives a change of scaley
+type heatmap struct {
+	Cols   int        `json:"cols"`
+	Rows   int        `json:"rows"`
+	Cells  []heatcell `json:"cells"`
+	Tracks int        `json:"tracks"` // learned from
+
+	filename string
+	changed  bool
+}
+
+const (
+	heattrained  = 50 // tracks before the heatmap is used for focusing
+	heatvisits   = 20 // sightings in a cell before its velocity is trusted
+	heatspread   = 0.5
+	heatfocusmin = 0.02 // share of the busiest cell's visits a cell needs to be looked at
+)
+
+func newheatmap(filename string) *heatmap {
+	return &heatmap{
+		Cols:     12,
+		Rows:     20,
+		Cells:    make([]heatcell, 12*20),
+		filename: filename,
+	}
+}
+
+func loadheatmap(filename string) (*heatmap, error) {
+	h := newheatmap(filename)
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	if err = json.Unmarshal(data, h); err != nil {
+		return h, fmt.Errorf("parsing %v: %v", filename, err)
+	}
+	if len(h.Cells) != h.Cols*h.Rows {
+		return newheatmap(filename), fmt.Errorf("%v has %v cells, expected %v", filename, len(h.Cells), h.Cols*h.Rows)
+	}
+	return h, nil
+}
+
+func (h *heatmap) save() error {
+	if !h.changed {
+		return nil
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	h.changed = false
+	return os.WriteFile(h.filename, data, 0644)
+}
+
+func (h *heatmap) cell(p image.Point, size image.Point) *heatcell {
+	col := p.X * h.Cols / size.X
+	row := p.Y * h.Rows / size.Y
+	if col < 0 || col >= h.Cols || row < 0 || row >= h.Rows {
+		return nil
+	}
+	return &h.Cells[row*h.Cols+col]
+}
+
+func (h *heatmap) cellrect(i int, size image.Point) image.Rectangle {
+	col, row := i%h.Cols, i/h.Cols
+	return image.Rect(col*size.X/h.Cols, row*size.Y/h.Rows, (col+1)*size.X/h.Cols, (row+1)*size.Y/h.Rows)
+}
+
+// learn adds a finished track
+func (h *heatmap) learn(tr *track, size image.Point) {
+	if c := h.cell(tr.positions[0], size); c != nil {
+		c.Spawns++
+	}
+	height := float64(size.Y)
+	for i := 1; i < len(tr.positions); i++ {
+		dt := tr.times[i].Sub(tr.times[i-1]).Seconds()
+		c := h.cell(tr.positions[i], size)
+		if dt <= 0 || c == nil {
+			continue
+		}
+		vx := float64(tr.positions[i].X-tr.positions[i-1].X) / dt / height
+		vy := float64(tr.positions[i].Y-tr.positions[i-1].Y) / dt / height
+		c.Visits++
+		c.VX += vx
+		c.VY += vy
+		c.Speed2 += vx*vx + vy*vy
+	}
+	for _, z := range tr.zaps {
+		if z.outcome == shotHit {
+			if c := h.cell(z.predicted, size); c != nil {
+				c.Hits++
+			}
+		}
+	}
+	h.Tracks++
+	h.changed = true
+}
+
+// velocity is how drones usually fly at p, in pixels per second, and the
+// variance of that per axis. False if drones there don't agree on it
+func (h *heatmap) velocity(p image.Point, size image.Point) (float64, float64, float64, bool) {
+	c := h.cell(p, size)
+	if c == nil || c.Visits < heatvisits {
+		return 0, 0, 0, false
+	}
+	n := float64(c.Visits)
+	vx, vy := c.VX/n, c.VY/n
+	variance := c.Speed2/n - (vx*vx + vy*vy)
+	if variance < 0 {
+		variance = 0
+	}
+	if math.Sqrt(variance) > heatspread*math.Hypot(vx, vy) {
+		return 0, 0, 0, false
+	}
+	height := float64(size.Y)
+	return vx * height, vy * height, variance / 2 * height * height, true
+}
+
+// likely is where drones are worth looking for, nil until enough has been learned
+func (h *heatmap) likely(size image.Point) []image.Rectangle {
+	if h.Tracks < heattrained {
+		return nil
+	}
+	busiest := 0
+	for _, c := range h.Cells {
+		if c.Visits+c.Spawns > busiest {
+			busiest = c.Visits + c.Spawns
+		}
+	}
+	var rects []image.Rectangle
+	for i, c := range h.Cells {
+		if float64(c.Visits+c.Spawns) >= heatfocusmin*float64(busiest) {
+			rects = append(rects, h.cellrect(i, size))
+		}
+	}
+	return rects
+}
+
+// preaim sharpens the velocity of a track seen twice with what drones have
+// done there before, if they agree, so it can be shot at right away
+func (h *heatmap) preaim(tr *track, size image.Point) bool {
+	if tr.hits != 2 || tr.state != trackTentative {
+		return false
+	}
+	lvx, lvy, variance, ok := h.velocity(tr.positions[1], size)
+	if !ok {
+		return false
+	}
+	vx, vy := tr.filter.velocity()
+	speed, learned := math.Hypot(vx, vy), math.Hypot(lvx, lvy)
+	if speed == 0 || learned == 0 {
+		return false
+	}
+	agreement := (vx*lvx + vy*lvy) / (speed * learned)
+	if agreement < 0.8 || speed < learned/2 || speed > learned*2 {
+		return false
+	}
+	tr.filter.updatevelocity(lvx, lvy, variance)
+	tr.preaimed = true
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// A drone still being tracked this long after the shot landed was missed
+	hitsettle = time.Millisecond * 150
+	// Give up on telling what happened after this long
+	hittimeout = time.Second * 3
+)
+
+// rewardarea is where the reward pops up when a drone at p is shot down
+func rewardarea(mat gocv.Mat, p image.Point) image.Rectangle {
+	size := mat.Cols() / 10
+	return image.Rect(p.X-size, p.Y-size, p.X+size, p.Y+size).Intersect(image.Rect(0, 0, mat.Cols(), mat.Rows()))
+}
+
+// rewardpixels counts reward coloured pixels around p
+func rewardpixels(mat gocv.Mat, p image.Point, cr colorrange) int {
+	return colorpixels(mat, rewardarea(mat, p), cr)
+}
+
+// screenregion is which ninth of the screen p is in, counting left to right, top to bottom
+func screenregion(mat gocv.Mat, p image.Point) int {
+	col := p.X * 3 / mat.Cols()
+	row := p.Y * 3 / mat.Rows()
+	if col < 0 {
+		col = 0
+	} else if col > 2 {
+		col = 2
+	}
+	if row < 0 {
+		row = 0
+	} else if row > 2 {
+		row = 2
+	}
+	return row*3 + col
+}
+
+// confirmshots decides what happened to the pending shots at a track. A
+// drone that keeps flying after the shot landed was missed. One that
+// vanished away from the screen edges with a reward popping up where it was
+// was hit. Anything else we can't tell. It's true if any shot got its result
+func confirmshots(tr *track, ended bool, mat gocv.Mat, now time.Time, dp droneprofile, s *stats) bool {
+	resolved := false
+	for i := range tr.zaps {
+		z := &tr.zaps[i]
+		if z.outcome != shotPending {
+			continue
+		}
+
+		// The reward only shows briefly, so look for it as soon as the drone is gone
+		if !tr.updated && now.After(z.fired) && !z.rewardseen {
+			area := rewardarea(mat, z.predicted)
+			if rewardpixels(mat, z.predicted, dp.Reward)-z.baseline > area.Dx()*area.Dy()/50 {
+				z.rewardseen = true
+			}
+		}
+
+		if !ended && tr.updated && now.After(z.lands.Add(hitsettle)) {
+			z.outcome = shotMiss
+		} else if ended {
+			last := tr.positions[len(tr.positions)-1]
+			edge := mat.Cols() / 20
+			switch {
+			case last.X < edge || last.X > mat.Cols()-edge || last.Y < edge || last.Y > mat.Rows()-edge:
+				z.outcome = shotUnknown
+			case z.rewardseen:
+				z.outcome = shotHit
+			default:
+				z.outcome = shotUnknown
+			}
+		} else if now.Sub(z.fired) > hittimeout {
+			z.outcome = shotUnknown
+		} else {
+			continue
+		}
+
+		// How far off the prediction was, if we saw where the drone really went
+		var predictionerror float64
+		actual, seen := tr.positionat(z.lands)
+		if !seen && z.outcome == shotHit && now.Sub(tr.lastseen) < time.Millisecond*100 {
+			actual, seen = tr.positions[len(tr.positions)-1], true
+		}
+		if seen {
+			predictionerror = math.Hypot(float64(actual.X-z.predicted.X), float64(actual.Y-z.predicted.Y))
+		}
+
+		fmt.Printf("Shot at drone %v: %v\n", tr.id, z.outcome)
+		s.shotresult(screenregion(mat, z.predicted), z.gesture, z.outcome, predictionerror, seen)
+		resolved = true
+	}
+	return resolved
+}
+
+func (so shotoutcome) String() string {
+	switch so {
+	case shotHit:
+		return "hit"
+	case shotMiss:
+		return "miss"
+	case shotUnknown:
+		return "unknown"
+	}
+	return "pending"
+}
@@ -0,0 +1,100 @@
+package main
+
+import "math"
+
+// hungarian solves the assignment problem for a rows x cols cost matrix,
+// returning the column assigned to each row, or -1. Pairs costing more
+// than maxcost are never assigned
+func hungarian(cost [][]float64, maxcost float64) []int {
+	rows := len(cost)
+	if rows == 0 {
+		return nil
+	}
+	cols := len(cost[0])
+
+	// Pad to a square matrix, gated and padded cells get a cost no real pair can beat
+	n := rows
+	if cols > n {
+		n = cols
+	}
+	forbidden := maxcost*float64(n+1) + 1
+	a := make([][]float64, n+1)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= n; j++ {
+			a[i][j] = forbidden
+			if i <= rows && j <= cols && cost[i-1][j-1] <= maxcost {
+				a[i][j] = cost[i-1][j-1]
+			}
+		}
+	}
+
+	// Kuhn-Munkres with potentials, 1-indexed
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				cur := a[i0][j] - u[i0] - v[j]
+				if cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+			if j0 == 0 {
+				break
+			}
+		}
+	}
+
+	assignment := make([]int, rows)
+	for i := range assignment {
+		assignment[i] = -1
+	}
+	for j := 1; j <= n; j++ {
+		i := p[j]
+		if i >= 1 && i <= rows && j <= cols && a[i][j] < forbidden {
+			assignment[i-1] = j - 1
+		}
+	}
+	return assignment
+}
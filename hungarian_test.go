@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHungarian(t *testing.T) {
+	tests := []struct {
+		name    string
+		cost    [][]float64
+		maxcost float64
+		want    []int
+	}{
+		{name: "nothing to assign", cost: nil, maxcost: 10, want: nil},
+		{name: "no columns", cost: [][]float64{{}, {}}, maxcost: 10, want: []int{-1, -1}},
+		{name: "one pair", cost: [][]float64{{3}}, maxcost: 10, want: []int{0}},
+		{name: "diagonal", cost: [][]float64{{1, 2}, {2, 1}}, maxcost: 10, want: []int{0, 1}},
+		{name: "better than greedy", cost: [][]float64{{1, 2}, {1, 10}}, maxcost: 20, want: []int{1, 0}},
+		{name: "more rows than columns", cost: [][]float64{{1}, {2}, {0.5}}, maxcost: 10, want: []int{-1, -1, 0}},
+		{name: "more columns than rows", cost: [][]float64{{5, 1, 3}}, maxcost: 10, want: []int{1}},
+		{name: "gated pair", cost: [][]float64{{100}}, maxcost: 10, want: []int{-1}},
+		{name: "gate leaves the cheaper one", cost: [][]float64{{100, 1}}, maxcost: 10, want: []int{1}},
+		{name: "only one can have the column", cost: [][]float64{{1, 50}, {2, 50}}, maxcost: 10, want: []int{0, -1}},
+		{name: "more pairs over cheaper ones", cost: [][]float64{{1, 2}, {1.5, 100}}, maxcost: 10, want: []int{1, 0}},
+		{name: "at the gate", cost: [][]float64{{10}}, maxcost: 10, want: []int{0}},
+	}
+	for _, test := range tests {
+		if got := hungarian(test.cost, test.maxcost); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: hungarian(%v, %v) = %v, want %v", test.name, test.cost, test.maxcost, got, test.want)
+		}
+	}
+}
+
+// Equal costs still give every row its own column
+func TestHungarianTies(t *testing.T) {
+	cost := [][]float64{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}}
+	got := hungarian(cost, 1)
+	taken := make(map[int]bool)
+	for row, col := range got {
+		if col < 0 || taken[col] {
+			t.Fatalf("row %v got column %v in %v", row, col, got)
+		}
+		taken[col] = true
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import "image"
+
+// kalman is a constant velocity filter in screen pixels and seconds, with
+// state x, y, vx, vy
+type kalman struct {
+	x [4]float64
+	p [4][4]float64
+
+	q float64 // process noise, acceleration variance in (pixels/s²)²
+	r float64 // measurement noise variance in pixels²
+}
+
+func newkalman(pos image.Point, q, r, velocityvariance float64) kalman {
+	k := kalman{
+		x: [4]float64{float64(pos.X), float64(pos.Y), 0, 0},
+		q: q,
+		r: r,
+	}
+	k.p[0][0] = r
+	k.p[1][1] = r
+	k.p[2][2] = velocityvariance
+	k.p[3][3] = velocityvariance
+	return k
+}
+
+// predict moves the state dt seconds ahead
+func (k *kalman) predict(dt float64) {
+	if dt <= 0 {
+		return
+	}
+
+	k.x[0] += k.x[2] * dt
+	k.x[1] += k.x[3] * dt
+
+	// P = F P F' + Q
+	var f [4][4]float64
+	for i := range f {
+		f[i][i] = 1
+	}
+	f[0][2] = dt
+	f[1][3] = dt
+
+	var fp, fpf [4][4]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for n := 0; n < 4; n++ {
+				fp[i][j] += f[i][n] * k.p[n][j]
+			}
+		}
+	}
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for n := 0; n < 4; n++ {
+				fpf[i][j] += fp[i][n] * f[j][n]
+			}
+		}
+	}
+
+	// White noise acceleration, per axis
+	dt2 := dt * dt
+	dt3 := dt2 * dt
+	dt4 := dt3 * dt
+	for axis := 0; axis < 2; axis++ {
+		fpf[axis][axis] += k.q * dt4 / 4
+		fpf[axis][axis+2] += k.q * dt3 / 2
+		fpf[axis+2][axis] += k.q * dt3 / 2
+		fpf[axis+2][axis+2] += k.q * dt2
+	}
+	k.p = fpf
+}
+
+// update folds in a measured position
+func (k *kalman) update(pos image.Point) {
+	// S = H P H' + R, only the position block
+	s00 := k.p[0][0] + k.r
+	s01 := k.p[0][1]
+	s10 := k.p[1][0]
+	s11 := k.p[1][1] + k.r
+	det := s00*s11 - s01*s10
+	if det == 0 {
+		return
+	}
+	i00, i01, i10, i11 := s11/det, -s01/det, -s10/det, s00/det
+
+	// K = P H' S^-1
+	var gain [4][2]float64
+	for i := 0; i < 4; i++ {
+		gain[i][0] = k.p[i][0]*i00 + k.p[i][1]*i10
+		gain[i][1] = k.p[i][0]*i01 + k.p[i][1]*i11
+	}
+
+	y0 := float64(pos.X) - k.x[0]
+	y1 := float64(pos.Y) - k.x[1]
+	for i := 0; i < 4; i++ {
+		k.x[i] += gain[i][0]*y0 + gain[i][1]*y1
+	}
+
+	// P = (I - K H) P
+	var p [4][4]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			p[i][j] = k.p[i][j] - gain[i][0]*k.p[0][j] - gain[i][1]*k.p[1][j]
+		}
+	}
+	k.p = p
+}
+
+// updatevelocity folds in a velocity known from elsewhere, with the given
+// variance per axis
+func (k *kalman) updatevelocity(vx, vy, variance float64) {
+	s00 := k.p[2][2] + variance
+	s01 := k.p[2][3]
+	s10 := k.p[3][2]
+	s11 := k.p[3][3] + variance
+	det := s00*s11 - s01*s10
+	if det == 0 {
+		return
+	}
+	i00, i01, i10, i11 := s11/det, -s01/det, -s10/det, s00/det
+
+	var gain [4][2]float64
+	for i := 0; i < 4; i++ {
+		gain[i][0] = k.p[i][2]*i00 + k.p[i][3]*i10
+		gain[i][1] = k.p[i][2]*i01 + k.p[i][3]*i11
+	}
+
+	y0 := vx - k.x[2]
+	y1 := vy - k.x[3]
+	for i := 0; i < 4; i++ {
+		k.x[i] += gain[i][0]*y0 + gain[i][1]*y1
+	}
+
+	var p [4][4]float64
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			p[i][j] = k.p[i][j] - gain[i][0]*k.p[2][j] - gain[i][1]*k.p[3][j]
+		}
+	}
+	k.p = p
+}
+
+func (k *kalman) position() image.Point {
+	return image.Pt(int(k.x[0]+0.5), int(k.x[1]+0.5))
+}
+
+// velocity in pixels per second
+func (k *kalman) velocity() (float64, float64) {
+	return k.x[2], k.x[3]
+}
+
+// peek is where the filter expects the target to be dt seconds from now
+func (k *kalman) peek(dt float64) image.Point {
+	return image.Pt(int(k.x[0]+k.x[2]*dt+0.5), int(k.x[1]+k.x[3]*dt+0.5))
+}
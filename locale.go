@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The embedded assets are the English UI, and everything without text in it
+const baselocale = "en"
+
+// localeprobe decides which language the game is running in. Until it knows,
+// the text bearing templates of every language are matched side by side,
+// keyed as name@locale, and whichever language keeps matching wins
+type localeprobe struct {
+	sets     map[string]map[string]*template // locale -> template name -> template
+	evidence map[string]int
+	decided  string
+}
+
+func newlocaleprobe(sets map[string]map[string]*template, forced string) *localeprobe {
+	lp := &localeprobe{
+		sets:     sets,
+		evidence: make(map[string]int),
+	}
+	if forced != "" {
+		if _, found := sets[forced]; !found && forced != baselocale {
+			fmt.Printf("No assets for locale %v, using %v\n", forced, baselocale)
+			forced = baselocale
+		}
+		lp.decided = forced
+	} else if len(sets) == 0 {
+		lp.decided = baselocale
+	}
+	return lp
+}
+
+// localized is true if some locale has its own version of the template
+func (lp *localeprobe) localized(name string) bool {
+	for _, set := range lp.sets {
+		if _, found := set[name]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// templates returns the set to match against
+func (lp *localeprobe) templates(base map[string]*template) map[string]*template {
+	active := make(map[string]*template, len(base))
+	for name, t := range base {
+		active[name] = t
+	}
+	if lp.decided != "" {
+		for name, t := range lp.sets[lp.decided] {
+			active[name] = t
+		}
+		return active
+	}
+	for locale, set := range lp.sets {
+		for name, t := range set {
+			active[name+"@"+locale] = t
+		}
+	}
+	return active
+}
+
+// observe counts which languages matched, and returns true when it has made up its mind
+func (lp *localeprobe) observe(results []result) bool {
+	if lp.decided != "" {
+		return false
+	}
+
+	for _, res := range results {
+		if res.confidence >= res.threshold {
+			continue
+		}
+		if res.locale != "" {
+			lp.evidence[res.locale]++
+		} else if lp.localized(res.name) {
+			lp.evidence[baselocale]++
+		}
+	}
+
+	var locales []string
+	for locale := range lp.evidence {
+		locales = append(locales, locale)
+	}
+	sort.Slice(locales, func(i, j int) bool {
+		return lp.evidence[locales[i]] > lp.evidence[locales[j]]
+	})
+	if len(locales) == 0 || lp.evidence[locales[0]] < 3 {
+		return false
+	}
+	if len(locales) > 1 && lp.evidence[locales[0]] < lp.evidence[locales[1]]*2 {
+		return false
+	}
+
+	lp.decided = locales[0]
+	fmt.Printf("Game language detected as %v (%v)\n", lp.decided, lp.evidence)
+	return true
+}
+
+// splitlocale turns a name@locale key back into the template name and its locale
+func splitlocale(key string) (string, string) {
+	name, locale, _ := strings.Cut(key, "@")
+	return name, locale
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"embed"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
 	"io/fs"
-	"strconv"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +20,14 @@ import (
 //go:embed assets/*
 var assets embed.FS
 
+func subfs(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
 func scale_pos(p image.Point) image.Point {
 	return image.Point{int(float64(p.X) / factor), int(float64(p.Y) / factor)}
 }
@@ -28,18 +37,144 @@ var factor float64
 func main() {
 	var e emulator
 
+	localeflag := flag.String("locale", "", "Game language (en, de, da ...), detected automatically if not set")
+	scriptsflag := flag.String("scripts", "scripts", "Folder with Starlark scripts (.star) for custom behaviour")
+	alertflag := flag.String("alert", "", "URL to post alerts (like full habs) to as plain text, for ntfy.sh or a chat webhook")
+	dailyflag := flag.String("daily", "daily.json", "Daily gifts to collect and when the game's day resets, the built in settings are used if the file doesn't exist")
+	profilesflag := flag.String("profiles", "farm_profiles.json", "How to behave on the home farm and on contract farms, the built in profiles are used if the file doesn't exist")
+	prestigeflag := flag.String("prestige", "prestige.json", "When to prestige, the built in settings (alert only) are used if the file doesn't exist")
+	boostsflag := flag.String("boosts", "boosts.json", "Boosts to use and when, none are used if the file doesn't exist")
+	artifactsflag := flag.String("artifacts", "artifacts.json", "File where collected artifacts are tallied, empty to only log them")
+	missionsflag := flag.String("missions", "missions.json", "Which rocket missions to launch, the built in settings are used if the file doesn't exist")
+	researchflag := flag.String("research", "research.json", "Which research to buy, the built in settings are used if the file doesn't exist")
+	adpolicyflag := flag.String("adpolicy", "ad_policy.json", "Which ads to watch and how many, the built in policy is used if the file doesn't exist")
+	rulesflag := flag.String("rules", "rules.json", "What the bot does and when, the built in rules are used if the file doesn't exist")
+	droneprofileflag := flag.String("droneprofile", "drone_profile.json", "File with drone colours and sizes")
+	recordflag := flag.String("record", "", "Save farm frames and drone detections to this folder")
+	calibrateflag := flag.String("calibrate", "", "Derive the drone profile from recorded frames with labels.json in this folder, then exit")
+	gestureflag := flag.String("gesture", "wiggle", "How to click drones: tap, wiggle, sweep, multitap, a comma separated list to take turns, or rotate for all of them")
+	heatmapflag := flag.String("heatmap", "drone_heatmap.json", "File where drone spawns, paths and hits are learned, empty to not learn")
+	motionflag := flag.Bool("motion", true, "Only look for drones where something moves")
+	synthflag := flag.String("synth", "", "Generate drone scenes from recorded frames with labels.json in this folder into its synthetic folder, test the drone code on them, then exit")
+	synthtestflag := flag.String("synthtest", "", "Test the drone code on already generated scenes in this folder, then exit")
+	flag.Parse()
+
 	scaley := 960
 
-	dronesizemin := 80
-	dronesizemax := 400
-	dronesizebrownmin := 25
-	dronesizebrownmax := 300
+	kernel := gocv.Ones(5, 5, gocv.MatTypeCV8U)
+
+	if *calibrateflag != "" {
+		base, err := loaddroneprofile(*droneprofileflag)
+		if err != nil {
+			fmt.Printf("Could not load drone profile: %v\n", err)
+			os.Exit(1)
+		}
+		dp, err := calibratedrones(base, *calibrateflag, scaley, kernel)
+		if err != nil {
+			fmt.Printf("Calibration failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err = dp.save(*droneprofileflag); err != nil {
+			fmt.Printf("Could not save drone profile: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Drone profile saved to %v: %+v\n", *droneprofileflag, dp)
+		return
+	}
+
+	dp, err := loaddroneprofile(*droneprofileflag)
+	if err != nil {
+		panic(err)
+	}
+	dp = dp.scaled(scaley)
+
+	if *synthflag != "" || *synthtestflag != "" {
+		scenes := *synthtestflag
+		if *synthflag != "" {
+			scenes = filepath.Join(*synthflag, "synthetic")
+			if err = generatesynth(*synthflag, scenes, defaultsynthconfig, scaley, dp, kernel); err != nil {
+				fmt.Printf("Generating scenes failed: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		report, err := testsynth(scenes, defaultsynthconfig, dp, kernel, *motionflag)
+		if err != nil {
+			fmt.Printf("Testing on scenes failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(report)
+		return
+	}
+
+	gestures, err := parsegestures(*gestureflag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	rotation := &gesturerotation{gestures: gestures}
+
+	var heat *heatmap
+	if *heatmapflag != "" {
+		heat, err = loadheatmap(*heatmapflag)
+		if err != nil {
+			fmt.Printf("Starting a new drone heatmap: %v\n", err)
+		}
+	}
+
+	var rec *recorder
+	if *recordflag != "" {
+		rec, err = newrecorder(*recordflag)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	//
 	thresholds := map[string]float32{
 		"default":                   0.04,
 		"ad_offer_watch_button":     0.055,
 		"max_chicken_running_bonus": 0.06,
+		"glyph":                     0.1,
+	}
+
+	engine, err := loadrules(*rulesflag)
+	if err != nil {
+		panic(err)
+	}
+	ads, err := loadadpolicy(*adpolicyflag)
+	if err != nil {
+		panic(err)
+	}
+	researching, err := loadresearch(*researchflag)
+	if err != nil {
+		panic(err)
+	}
+	missions, err := loadmissions(*missionsflag)
+	if err != nil {
+		panic(err)
+	}
+	daily, err := loaddaily(*dailyflag)
+	if err != nil {
+		panic(err)
+	}
+	profiles, err := loadfarmprofiles(*profilesflag)
+	if err != nil {
+		panic(err)
+	}
+	prestige, err := loadprestige(*prestigeflag)
+	if err != nil {
+		panic(err)
+	}
+	boosts, err := loadboosts(*boostsflag)
+	if err != nil {
+		panic(err)
+	}
+	artifacts, err := loadinventory(*artifactsflag)
+	if err != nil {
+		panic(err)
+	}
+	if len(artifacts.Counts) > 0 {
+		fmt.Printf("Artifacts collected so far:\n%v", artifacts.summary())
 	}
 
 	debug := true
@@ -47,7 +182,7 @@ func main() {
 
 	templates := make(map[string]*template)
 
-	err := e.Open(LDPlayer9)
+	err = e.Open(LDPlayer9)
 	if err != nil {
 		panic(err)
 	}
@@ -59,83 +194,82 @@ func main() {
 
 	// Load templates
 	detector := gocv.NewORB()
-	fs.WalkDir(assets, ".", func(path string, file fs.DirEntry, err error) error {
-		if strings.HasSuffix(file.Name(), ".png") {
-			imagedata, err := assets.Open(path)
+	var reader ocr
+	loadassets := func(fsys fs.FS, templates map[string]*template) {
+		fs.WalkDir(fsys, ".", func(path string, file fs.DirEntry, err error) error {
 			if err != nil {
-				panic(err)
-			}
-			loadedimage, err := png.Decode(imagedata)
-			if err != nil {
-				panic(err)
+				return err
 			}
+			if strings.HasSuffix(file.Name(), ".png") {
+				basename, t, err := loadtemplate(fsys, path, scaley, &detector)
+				if err != nil {
+					panic(err)
+				}
 
-			mat, _ := gocv.ImageToMatRGB(loadedimage)
-
-			var alphaimage *image.Gray
-			if loadedimage.ColorModel() == color.NRGBAModel {
-				alphaimage = image.NewGray(loadedimage.Bounds())
-				for x := 0; x < loadedimage.Bounds().Dx(); x++ {
-					for y := 0; y < loadedimage.Bounds().Dy(); y++ {
-						_, _, _, a := loadedimage.At(x, y).RGBA()
-						av := uint8(a)
-						alphaimage.Set(x, y, color.RGBA{av, av, av, av})
+				threshold, found := thresholds[basename]
+				if !found {
+					threshold = thresholds["default"]
+					if strings.HasPrefix(basename, "glyph_") {
+						threshold = thresholds["glyph"]
 					}
 				}
-			}
+				t.threshold = threshold
 
-			mask := gocv.NewMat()
-			if alphaimage != nil {
-				tmask, _ := gocv.ImageGrayToMatGray(alphaimage)
-				tmask.ConvertTo(&mask, gocv.MatTypeCV32FC3)
-				tmask.Close()
-			}
-
-			kps, m := detector.DetectAndCompute(mat, mask)
-			m.Close()
-
-			basename, height, found := strings.Cut(strings.TrimSuffix(file.Name(), ".png"), ".")
-			if found {
-				h, _ := strconv.ParseInt(height, 10, 64)
-				factor := float64(scaley) / float64(h)
-				oldx, oldy := mat.Cols(), mat.Rows()
-				gocv.Resize(mat, &mat, image.Point{}, factor, factor, gocv.InterpolationLanczos4)
-				fmt.Printf("Resized %s from %d,%d to %d,%d\n", basename, oldx, oldy, mat.Cols(), mat.Rows())
-				if mask.Cols() > 0 {
-					gocv.Resize(mask, &mask, image.Point{}, factor, factor, gocv.InterpolationLanczos4)
+				if !reader.add(basename, t) {
+					templates[basename] = t
 				}
 			}
+			return nil
+		})
+	}
+	loadassets(assets, templates)
 
-			threshold, found := thresholds[basename]
-			if !found {
-				threshold = thresholds["default"]
-			}
-
-			templates[basename] = &template{
-				threshold: threshold,
-				mat:       mat,
-				mask:      mask,
-				keypoints: kps,
-			}
+	// OCR glyphs are cut from your own screenshots and dropped in here
+	if _, err := os.Stat("glyphs"); err == nil {
+		loadassets(os.DirFS("glyphs"), templates)
+	}
+	ocrregions = readableregions(ocrregions, templates)
+	missions.check(templates)
+
+	// Translated versions of templates with text in them, from the locales folder
+	localesets := make(map[string]map[string]*template)
+	localefs := os.DirFS("locales")
+	entries, _ := fs.ReadDir(localefs, ".")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
 		}
-		return nil
-	})
+		localesets[entry.Name()] = make(map[string]*template)
+		loadassets(subfs(localefs, entry.Name()), localesets[entry.Name()])
+		fmt.Printf("Loaded %v templates for locale %v\n", len(localesets[entry.Name()]), entry.Name())
+	}
+	locales := newlocaleprobe(localesets, *localeflag)
+	if reader.enabled() {
+		fmt.Printf("Loaded %v OCR glyphs\n", len(reader.glyphs))
+	} else {
+		fmt.Println("No OCR glyphs found, counters will not be read")
+	}
 
 	var resultlock sync.Mutex
 	var lastimagetime time.Time
 	var lastimage gocv.Mat
 
-	var lastdronetakedowns []takedowninfo
+	var lastdronetracks []track
 	var lastresultstime time.Time
 	var lastresults []result
 
+	state := gamestate{
+		readings: make(map[string]reading),
+	}
+
 	var lastdronetime = time.Now()
 	var lastoktime = time.Now()
+	var lastcameramove time.Time
 
 	running := true
 	shoot_drones := true
 
-	kernel := gocv.Ones(5, 5, gocv.MatTypeCV8U)
+	botstats := newstats()
 
 	// high speed screen capture, resize
 	go func() {
@@ -145,6 +279,8 @@ func main() {
 				continue
 			}
 
+			// Everything downstream measures its delay from when the pixels were grabbed
+			captured := time.Now()
 			capture, err := e.Capture()
 			if err != nil {
 				// log.Warn().Error(err)
@@ -164,214 +300,217 @@ func main() {
 			oldimage := lastimage
 			lastimage = screenmat
 			oldimage.Close()
-			lastimagetime = time.Now()
+			lastimagetime = captured
 			resultlock.Unlock()
 		}
 	}()
 
-	// Drone detection
+	// Drone detection, closes dronesdone when it has saved what it learned
+	dronesdone := make(chan struct{})
 	go func() {
+		defer close(dronesdone)
 		var lastdroneprocessed time.Time
-	runningloop:
+		var drones *tracker
+		var sched *scheduler
+		var motion *motiondetector
+		var focusing bool
+		var cameramoved time.Time
+		lastheatsave := time.Now()
 		for running {
-			if shoot_drones && !e.IsForeground() && !lastdroneprocessed.Equal(lastimagetime) {
-				lastdroneprocessed = lastimagetime
-
+			if shoot_drones && state.screen == screenFarmMain && !e.IsForeground() && !lastdroneprocessed.Equal(lastimagetime) {
 				resultlock.Lock()
 				dronedetectmat := lastimage.Clone()
-				dronetakedowns := make([]takedowninfo, len(lastdronetakedowns))
-				copy(dronetakedowns, lastdronetakedowns)
+				frametime := lastimagetime
+				current := state.screen
+				moved := lastcameramove
 				resultlock.Unlock()
+				lastdroneprocessed = frametime
+
+				if drones == nil {
+					drones = newtracker(dronedetectmat.Cols())
+					sched = newscheduler(dronedetectmat.Cols())
+					if *motionflag {
+						motion = newmotiondetector(dronedetectmat.Cols())
+					}
+				}
+				// Props seen before the camera moved are somewhere else now
+				if moved.After(cameramoved) {
+					motion.forget()
+					cameramoved = moved
+				}
 
-				if len(dronetakedowns) > 100 {
-					var newdronetakedowns []takedowninfo
-					for _, ti := range dronetakedowns {
-						if time.Since(ti.timeout) < 0 {
-							newdronetakedowns = append(newdronetakedowns, ti)
+				// Drones are never where the masks are, whatever looks like one there is UI
+				screensize := image.Pt(dronedetectmat.Cols(), dronedetectmat.Rows())
+				excluded := func(p image.Point) bool {
+					return dp.masked(p, screensize, current)
+				}
+
+				var detections []image.Point
+				var dronedetections []dronedetection
+				// Short on CPU, only look where drones usually are and around the ones we're following
+				pipeline := botstats.pipelinetime()
+				if !focusing && pipeline > time.Millisecond*100 {
+					focusing = true
+					fmt.Printf("Drone detection taking %v, focusing on likely areas\n", pipeline.Round(time.Millisecond))
+				} else if focusing && pipeline < time.Millisecond*60 {
+					focusing = false
+				}
+				var focus []image.Rectangle
+				if focusing && heat != nil {
+					focus = heat.likely(screensize)
+					if focus != nil {
+						for _, tr := range drones.tracks {
+							focus = append(focus, image.Rectangle{Min: tr.filter.position(), Max: tr.filter.position()}.Inset(-int(drones.gate)))
 						}
 					}
-					dronetakedowns = newdronetakedowns
-				}
-
-				// drone detection - it's something black and brown
-				blackdetect := gocv.NewMat()
-				gocv.InRangeWithScalar(dronedetectmat, gocv.Scalar{30, 30, 30, 0}, gocv.Scalar{50, 50, 50, 0}, &blackdetect)
-				dilatedblack := gocv.NewMat()
-				gocv.Dilate(blackdetect, &dilatedblack, kernel)
-				blackdetect.Close()
-				blackcontours := gocv.FindContours(dilatedblack, gocv.RetrievalExternal, gocv.ChainApproxSimple)
-				dilatedblack.Close()
-
-				browndetect := gocv.NewMat()
-				gocv.InRangeWithScalar(dronedetectmat, gocv.Scalar{70, 142, 183, 0}, gocv.Scalar{90, 162, 203, 0}, &browndetect)
-				dilatedbrown := gocv.NewMat()
-				gocv.Dilate(browndetect, &dilatedbrown, kernel)
-				browndetect.Close()
-				browncontours := gocv.FindContours(dilatedbrown, gocv.RetrievalExternal, gocv.ChainApproxSimple)
-				dilatedbrown.Close()
-
-				var blackrects, brownrects []image.Rectangle
-
-				for _, contour := range blackcontours.ToPoints() {
-					pv := gocv.NewPointVectorFromPoints(contour)
-					bb := gocv.BoundingRect(pv)
-					area := bb.Dx() * bb.Dy()
-
-					if area > dronesizemin &&
-						area < dronesizemax &&
-						// (bb.Min.X > dronedetectmat.Cols()*25/100 ||
-						// 	bb.Min.Y > dronedetectmat.Rows()*33/100) &&
-						bb.Min.Y > dronedetectmat.Rows()*11/100 &&
-						bb.Max.Y < dronedetectmat.Rows()*88/100 {
-						blackrects = append(blackrects, bb)
+				}
+
+				for _, dd := range motion.detect(dronedetectmat, dp, kernel, focus) {
+					if !excluded(dd.position) {
+						dronedetections = append(dronedetections, dd)
 					}
+				}
+				for _, dd := range dronedetections {
+					fmt.Printf("Drone detected at %v, black area %v, brown area %v\n", dd.position, dd.black.Dx()*dd.black.Dy(), dd.brown.Dx()*dd.brown.Dy())
+					detections = append(detections, dd.position)
+				}
+				if rec != nil {
+					rec.record(dronedetectmat, frametime, dronedetections)
+				}
 
-					pv.Close()
+				detectedtracks := drones.update(detections, frametime)
+				if heat != nil {
+					for _, tr := range drones.tracks {
+						if heat.preaim(tr, screensize) {
+							fmt.Printf("Drone %v flies like drones there usually do, aiming after two sightings\n", tr.id)
+						}
+					}
 				}
-				blackcontours.Close()
+				for _, tr := range drones.tracks {
+					if tr.state == trackConfirmed && tr.updated && frametime.Sub(tr.firstseen) > time.Second*2 &&
+						distance(tr.positions[len(tr.positions)-1], tr.positions[0]) < dronedetectmat.Cols()/15 {
+						motion.stillat(tr.positions[len(tr.positions)-1])
+					}
+				}
+				botstats.frameprocessed(frametime)
 
-				for _, contour := range browncontours.ToPoints() {
-					pv := gocv.NewPointVectorFromPoints(contour)
-					bb := gocv.BoundingRect(pv)
-					area := bb.Dx() * bb.Dy()
+				for i, tr := range detectedtracks {
+					tr.votes++
+					if dronedetections[i].elite(dp, tr.speed()) {
+						tr.elitevotes++
+					}
+				}
 
-					if area > dronesizebrownmin &&
-						area < dronesizebrownmax &&
-						// (bb.Min.X > dronedetectmat.Cols()*25/100 ||
-						// 	bb.Min.Y > dronedetectmat.Rows()*33/100) &&
-						bb.Min.Y > dronedetectmat.Rows()*11/100 &&
-						bb.Max.Y < dronedetectmat.Rows()*88/100 {
-						brownrects = append(brownrects, bb)
+				for _, tr := range drones.tracks {
+					if confirmshots(tr, false, dronedetectmat, frametime, dp, botstats) {
+						sched.resolved()
+					}
+				}
+				for _, tr := range drones.ended {
+					if confirmshots(tr, true, dronedetectmat, frametime, dp, botstats) {
+						sched.resolved()
 					}
+					if heat != nil {
+						heat.learn(tr, screensize)
+					}
+				}
+				if heat != nil && time.Since(lastheatsave) > time.Minute*5 {
+					if err := heat.save(); err != nil {
+						fmt.Printf("Could not save drone heatmap: %v\n", err)
+					}
+					lastheatsave = time.Now()
+				}
+				if len(detections) > 0 {
+					resultlock.Lock()
+					lastdronetime = time.Now()
+					resultlock.Unlock()
+				}
 
-					pv.Close()
+				var candidates []*track
+				for _, tr := range drones.tracks {
+					if sched.eligible(tr, dronedetectmat.Cols()) {
+						candidates = append(candidates, tr)
+					}
 				}
-				browncontours.Close()
 
-				for _, blackrect := range blackrects {
-					for _, brownrect := range brownrects {
-						if blackrect.Overlaps(brownrect) {
-							dronearea := blackrect.Intersect(brownrect)
-							drone := image.Point{
-								X: dronearea.Min.X + dronearea.Dx()/2,
-								Y: dronearea.Min.Y + dronearea.Dy()/2,
-							}
+				// Fire a sequence of shots from this frame in the planned order, aiming
+				// each one again as the others keep flying while we're busy
+				bounds := image.Rect(0, int(dp.BandTop*float64(dronedetectmat.Rows())), dronedetectmat.Cols(), int(dp.BandBottom*float64(dronedetectmat.Rows())))
+				planlead := botstats.lead(rotation.next())
+				if busy := time.Since(frametime); busy > planlead {
+					planlead = busy
+				}
+				var fired []image.Point
+				for _, ps := range sched.order(candidates, bounds, excluded, planlead) {
+					if len(fired) >= sched.maxshots {
+						break
+					}
+					// Predict where the drone is going to be when the shot lands. The filter is
+					// at the time the frame was captured, so lead by the measured latency,
+					// or by how long we've been busy with this frame if we already shot at others
+					g := rotation.next()
+					lead := botstats.lead(g)
+					if busy := time.Since(frametime) + botstats.gestureduration(g); busy > lead {
+						lead = busy
+					}
+
+					tr := ps.track
+					predicted := tr.filter.peek(lead.Seconds())
+					if !sched.clear(predicted, bounds, excluded, fired) {
+						continue
+					}
+					drone := tr.positions[len(tr.positions)-1]
+					fmt.Printf("Drone %v is at %v, %v predicting it should be at %v, %v in %v\n", tr.id, drone.X, drone.Y, predicted.X, predicted.Y, lead.Round(time.Millisecond))
 
-							blackarea := blackrect.Dx() * blackrect.Dy()
-							brownarea := brownrect.Dx() * brownrect.Dy()
-
-							fmt.Printf("Drone detected at %v, black area %v, brown area %v\n", drone, blackarea, brownarea)
-
-							if true || blackarea*2 > brownarea*3 {
-								closestindex := -1
-								closestdist := 10000
-
-								// Find cached info on the closest drone currently on screen
-								for i, ti := range dronetakedowns {
-									if time.Since(ti.timeout) > 0 {
-										continue
-									}
-
-									if closestindex == -1 || distance(drone, ti.positions[len(ti.positions)-1]) < closestdist {
-										closestindex = i
-										closestdist = distance(drone, ti.positions[len(ti.positions)-1])
-									}
-								}
-
-								// This is a new drone or it moved too much to be the same drone, add it and wait for it to be seen again to get vectors
-								if closestindex == -1 || closestdist > dronedetectmat.Cols()/5 {
-									dronetakedowns = append(dronetakedowns, takedowninfo{
-										timeout:         time.Now().Add(time.Second),
-										initialposition: drone,
-										positions:       []image.Point{drone},
-										seencount:       1,
-									})
-									resultlock.Lock()
-									lastdronetime = time.Now()
-									lastdronetakedowns = dronetakedowns
-									resultlock.Unlock()
-
-									continue
-								}
-
-								// Not new, so add new info to it
-								dronetakedowns[closestindex].timeout = time.Now().Add(time.Second)
-								dronetakedowns[closestindex].positions = append(dronetakedowns[closestindex].positions, drone)
-								dronetakedowns[closestindex].seencount++
-
-								resultlock.Lock()
-								lastdronetime = time.Now()
-								lastdronetakedowns = dronetakedowns
-								resultlock.Unlock()
-
-								if distance(drone, dronetakedowns[closestindex].positions[0]) < dronedetectmat.Cols()/15 {
-									// Non-moving false positive
-									continue
-								}
-
-								// Should we zap it?
-								if len(dronetakedowns[closestindex].zaps) > 0 &&
-									distance(drone, dronetakedowns[closestindex].zaps[len(dronetakedowns[closestindex].zaps)-1].position) < dronedetectmat.Cols()/6 {
-									// Nope
-									continue
-								}
-
-								// Predict where drone is going to be
-								predicted := drone
-								lastindex := len(dronetakedowns[closestindex].positions) - 1
-								firstindex := 0
-								if len(dronetakedowns[closestindex].positions) > 5 {
-									firstindex = len(dronetakedowns[closestindex].positions) - 5
-								}
-
-								if lastindex-firstindex > 3 {
-									firstdronepos := dronetakedowns[closestindex].positions[firstindex]
-
-									// Predict based on current position and velocity
-									offset := image.Point{
-										X: (drone.X - firstdronepos.X) / ((lastindex - firstindex + 1) / 2),
-										Y: (drone.Y - firstdronepos.Y) / ((lastindex - firstindex + 1) / 2),
-									}
-
-									predicted = drone.Add(offset)
-									fmt.Printf("Drone is at %v, %v predicting it should be at %v, %v\n", drone.X, drone.Y, predicted.X, predicted.Y)
-								}
-
-								// Light it up
-								fmt.Printf("Shooting down drone at %v, %v\n", predicted.X, predicted.Y)
-
-								predicted_pos_scaled := scale_pos(predicted)
-
-								e.MouseDown(predicted_pos_scaled)
-
-								for i := 0; i <= 5; i++ {
-									time.Sleep(time.Millisecond * 3)
-									e.MouseDrag(predicted_pos_scaled.Add(image.Pt(0, i*3)))
-								}
-								for i := 5; i >= 0; i-- {
-									time.Sleep(time.Millisecond * 3)
-									e.MouseDrag(predicted_pos_scaled.Add(image.Pt(0, i*3)))
-								}
-								e.MouseUp(predicted_pos_scaled)
-
-								dronetakedowns[closestindex].zaps = append(dronetakedowns[closestindex].zaps,
-									zap{
-										position:  drone,
-										predicted: predicted,
-									})
-
-								resultlock.Lock()
-								lastdronetime = time.Now()
-								lastdronetakedowns = dronetakedowns
-								resultlock.Unlock()
-
-								dronedetectmat.Close()
-								continue runningloop
+					// Light it up
+					kind := "drone"
+					if tr.elite() {
+						kind = "elite drone"
+					}
+
+					// Only the aim point was planned clear of the masks, a gesture reaching
+					// into one could open whatever is there, so tap instead
+					vx, vy := tr.filter.velocity()
+					spread := dronedetectmat.Cols() / 30
+					if g != gestureTap {
+						for _, p := range g.points(predicted, vx, vy, spread) {
+							if excluded(p) || !p.In(bounds) {
+								fmt.Printf("The %v would touch %v, %v, tapping instead\n", g, p.X, p.Y)
+								g = gestureTap
+								break
 							}
 						}
 					}
+					if g == rotation.next() {
+						rotation.fired()
+					}
+
+					fmt.Printf("Shooting down %v %v at %v, %v with %v\n", kind, tr.id, predicted.X, predicted.Y, g)
+					down := time.Now()
+					g.fire(&e, predicted, vx, vy, spread)
+					botstats.shotfired(frametime, down, time.Now(), g)
+
+					tr.zaps = append(tr.zaps,
+						zap{
+							position:  drone,
+							predicted: predicted,
+							fired:     down,
+							gesture:   g,
+							lands:     frametime.Add(lead),
+							baseline:  rewardpixels(dronedetectmat, predicted, dp.Reward),
+						})
+
+					resultlock.Lock()
+					lastdronetime = time.Now()
+					resultlock.Unlock()
+
+					fired = append(fired, predicted)
 				}
 
+				resultlock.Lock()
+				lastdronetracks = drones.snapshot()
+				resultlock.Unlock()
+
 				dronedetectmat.Close()
 			} else {
 				// So it doesnt time out when we get back
@@ -379,6 +518,14 @@ func main() {
 			}
 			time.Sleep(time.Millisecond * 5)
 		}
+		if heat != nil {
+			if err := heat.save(); err != nil {
+				fmt.Printf("Could not save drone heatmap: %v\n", err)
+			}
+		}
+		if rec != nil && rec.unsaved > 0 {
+			rec.flush()
+		}
 	}()
 
 	var ad_started time.Time
@@ -386,6 +533,8 @@ func main() {
 
 	// Image detection
 	go func() {
+		var classifier screenclassifier
+		active := locales.templates(templates)
 
 		for running {
 			if !e.IsForeground() && !lastimagetime.IsZero() && lastimagetime.After(lastresultstime) && time.Since(lastresultstime) > time.Millisecond*1000 {
@@ -393,22 +542,24 @@ func main() {
 				screenmat := lastimage.Clone()
 				resultlock.Unlock()
 
-				results := make([]result, len(templates))
+				results := make([]result, len(active))
 
 				var wg sync.WaitGroup
 
-				wg.Add(len(templates))
+				wg.Add(len(active))
 
 				var i int
-				for name, t := range templates {
-					go func(name string, t *template, i int) {
+				for key, t := range active {
+					go func(key string, t *template, i int) {
 						resultmat := gocv.NewMat()
 						gocv.MatchTemplate(screenmat, t.mat, &resultmat, gocv.TmSqdiffNormed, t.mask)
 						confidence, _, loc, _ := gocv.MinMaxLoc(resultmat)
 
+						name, locale := splitlocale(key)
 						middle := loc.Add(image.Point{t.mat.Cols() / 2, t.mat.Rows() / 2})
 						results[i] = result{
 							name:       name,
+							locale:     locale,
 							confidence: confidence,
 							threshold:  t.threshold,
 							location:   middle,
@@ -416,27 +567,278 @@ func main() {
 						}
 						resultmat.Close()
 						wg.Done()
-					}(name, t, i)
+					}(key, t, i)
 					i++
 				}
 
 				wg.Wait()
 
+				if locales.observe(results) {
+					active = locales.templates(templates)
+				}
+
+				current, screenconfidence := classifier.classify(results, screenmat, watching_ad)
+
+				// Read counters that are on this screen
+				readings := make(map[string]reading)
+				if reader.enabled() {
+					visible := make(map[string]bool)
+					for _, res := range results {
+						if res.confidence < res.threshold {
+							visible[res.name] = true
+						}
+					}
+					for _, region := range ocrregions {
+						if region.requires != "" && visible[region.requires] {
+							rd := reader.readregion(screenmat, region)
+							if rd.confidence > 0 {
+								readings[region.name] = rd
+							}
+						}
+					}
+				}
+
 				screenmat.Close()
 
 				resultlock.Lock()
 				lastresults = results
 				lastresultstime = time.Now()
+				for name, rd := range readings {
+					state.readings[name] = rd
+				}
+				if current != state.screen {
+					fmt.Printf("Screen changed from %v to %v (%.2f)\n", state.screen, current, screenconfidence)
+					state.screenchanged = time.Now()
+				}
+				state.screen = current
+				state.screenconfidence = screenconfidence
 				resultlock.Unlock()
 			}
 		}
 	}()
 
+	// What rules can do
+	adstarted := func() {
+		shoot_drones = false
+		watching_ad = true
+		ad_started = time.Now()
+		lastoktime = time.Now()
+	}
+	addone := func() {
+		watching_ad = false
+		shoot_drones = true
+		lastdronetime = time.Now()
+		lastoktime = time.Now()
+	}
+	drag := func(from, to image.Point) {
+		e.MouseDown(scale_pos(from))
+		for i := 1; i <= 10; i++ {
+			time.Sleep(time.Millisecond * 3)
+			e.MouseDrag(scale_pos(from.Add(to.Sub(from).Mul(i).Div(10))))
+		}
+		e.MouseUp(scale_pos(to))
+	}
+	alerts := newalerter(*alertflag)
+	hatch := newhatchery()
+	menus := &session{
+		capture: func() gocv.Mat {
+			asked := time.Now()
+			for time.Since(asked) < time.Second*2 {
+				resultlock.Lock()
+				if lastimagetime.After(asked) {
+					mat := lastimage.Clone()
+					resultlock.Unlock()
+					return mat
+				}
+				resultlock.Unlock()
+				time.Sleep(time.Millisecond * 10)
+			}
+			// Capturing has stopped, the last screen is all there is
+			fmt.Println("No new screen captured, using the last one")
+			resultlock.Lock()
+			defer resultlock.Unlock()
+			return lastimage.Clone()
+		},
+		screen: func() gamescreen {
+			asked := time.Now()
+			for time.Since(asked) < time.Second*5 {
+				resultlock.Lock()
+				if lastresultstime.After(asked) {
+					current := state.screen
+					resultlock.Unlock()
+					return current
+				}
+				resultlock.Unlock()
+				time.Sleep(time.Millisecond * 50)
+			}
+			return screenUnknown
+		},
+		templates: templates,
+		groups:    engine.groups,
+		reader:    &reader,
+		click: func(p image.Point, repeat int) {
+			e.Click(scale_pos(p), repeat)
+		},
+		drag: drag,
+		press: func(p image.Point) {
+			e.MouseDown(scale_pos(p))
+		},
+		release: func(p image.Point) {
+			e.MouseUp(scale_pos(p))
+		},
+		back: func() {
+			e.SendKey(e.Config.Back, 1)
+		},
+	}
+	actions := map[string]ruleaction{
+		"wait": func(r *rule, ctx *rulecontext, target image.Point) {},
+		"click": func(r *rule, ctx *rulecontext, target image.Point) {
+			e.Click(scale_pos(target), r.clicks())
+		},
+		// Clicking something that shows the game is alive
+		"acknowledge": func(r *rule, ctx *rulecontext, target image.Point) {
+			e.Click(scale_pos(target), r.clicks())
+			lastoktime = time.Now()
+		},
+		"launch": func(r *rule, ctx *rulecontext, target image.Point) {
+			e.Click(scale_pos(target), r.clicks())
+			shoot_drones = true
+		},
+		"watch_ad": func(r *rule, ctx *rulecontext, target image.Point) {
+			e.Click(scale_pos(target), r.clicks())
+			adstarted()
+		},
+		// Watch the ad if the ad policy allows it, otherwise say no thanks
+		"offered_ad": func(r *rule, ctx *rulecontext, target image.Point) {
+			offer := r.Target
+			if best, found := bestoffer(ctx.scores); found {
+				offer = best
+			}
+			watch, why := ads.decide(offer, ctx.now)
+			if watch {
+				fmt.Printf("Watching ad, %v\n", why)
+				e.Click(scale_pos(target), 1)
+				adstarted()
+				ads.started(offer)
+				return
+			}
+			fmt.Printf("Not watching ad, %v\n", why)
+			if p, found := engine.lookup(ctx.seen, "ad_offer_no_thanks_button"); found {
+				e.Click(scale_pos(p), 1)
+			} else if p, found := engine.lookup(ctx.seen, "close"); found {
+				e.Click(scale_pos(p), 1)
+			}
+			lastoktime = time.Now()
+		},
+		// Ads count towards the caps once they're done, not when they time out
+		"ad_done": func(r *rule, ctx *rulecontext, target image.Point) {
+			ads.finished(ctx.now)
+			addone()
+		},
+		"ad_timeout": func(r *rule, ctx *rulecontext, target image.Point) {
+			e.SendKey(uintptr(e.Config.Home), 1)
+			ads.abandoned(ctx.now)
+			addone()
+		},
+		"restart_app": func(r *rule, ctx *rulecontext, target image.Point) {
+			fmt.Println("Clearning app task list")
+			for i := 0; i < 3; i++ {
+				e.SendKey(e.Config.AppSwitcher, 1)
+				time.Sleep(time.Millisecond * 500)
+				e.SendKey(win.VK_DELETE, 1)
+				time.Sleep(time.Millisecond * 500)
+				e.SendKey(e.Config.Escape, 1)
+				time.Sleep(time.Millisecond * 500)
+			}
+			e.SendKey(e.Config.Home, 1)
+
+			lastdronetime = time.Now()
+			lastoktime = time.Now()
+		},
+		// Hold the chicken button, while keeping the drone clicks away from it
+		"hatch": func(r *rule, ctx *rulecontext, target image.Point) {
+			shoot_drones = false
+			hatch.hatch(menus, alerts)
+			shoot_drones = true
+		},
+		// Read what the reward is before collecting it
+		"artifact": func(r *rule, ctx *rulecontext, target image.Point) {
+			artifacts.collect(menus)
+			lastoktime = time.Now()
+		},
+		"daily_reward": func(r *rule, ctx *rulecontext, target image.Point) {
+			if daily.collect(menus, ads) {
+				adstarted()
+			}
+			lastoktime = time.Now()
+		},
+		"prestige": func(r *rule, ctx *rulecontext, target image.Point) {
+			shoot_drones = false
+			prestige.prestige(menus, alerts, ctx.readings)
+			shoot_drones = true
+			lastoktime = time.Now()
+		},
+		"boosts": func(r *rule, ctx *rulecontext, target image.Point) {
+			if plan := boosts.due(ctx, engine); plan != nil {
+				boosts.use(menus, plan)
+			}
+			lastoktime = time.Now()
+		},
+		"missions": func(r *rule, ctx *rulecontext, target image.Point) {
+			shoot_drones = false
+			missions.missions(menus, artifacts)
+			shoot_drones = true
+			lastoktime = time.Now()
+		},
+		"research": func(r *rule, ctx *rulecontext, target image.Point) {
+			shoot_drones = false
+			researching.research(menus)
+			shoot_drones = true
+			lastoktime = time.Now()
+		},
+		"move_to_silo": func(r *rule, ctx *rulecontext, target image.Point) {
+			middle := scale_pos(ctx.size.Div(2))
+			e.MouseDown(middle)
+			for i := 0; i < 10; i++ {
+				time.Sleep(time.Millisecond * 3)
+				e.MouseDrag(middle.Add(image.Pt(i*3, i*3)))
+			}
+			e.MouseUp(middle.Add(image.Pt(30, 30)))
+			resultlock.Lock()
+			lastcameramove = time.Now()
+			resultlock.Unlock()
+		},
+	}
+	if err = engine.check(actions, templates); err != nil {
+		panic(err)
+	}
+
+	scripts, err := loadscripts(*scriptsflag, scriptapi{
+		actions: actions,
+		engine:  engine,
+		click: func(p image.Point, repeat int) {
+			e.Click(scale_pos(p), repeat)
+		},
+		drag: drag,
+		keys: map[string]uintptr{
+			"home":        e.Config.Home,
+			"back":        e.Config.Back,
+			"escape":      e.Config.Escape,
+			"appswitcher": e.Config.AppSwitcher,
+			"delete":      win.VK_DELETE,
+		},
+		sendkey: func(key uintptr) {
+			e.SendKey(key, 1)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
 	go func() {
 		var lastactiontime time.Time
-		var last_double_video_boosts_open time.Time
-		var last_ad_icon, last_package_icon image.Point
 		var lastblurtime time.Time
+		var scriptscreen gamescreen
 
 		for running {
 			if !e.IsForeground() && !lastactiontime.Equal(lastresultstime) {
@@ -445,112 +847,31 @@ func main() {
 				screen := lastimage.Clone()
 				results := make([]result, len(lastresults))
 				copy(results, lastresults)
-				// image_max_y := lastimage.Rows()
+				current := state.screen
+				readings := make(map[string]reading, len(state.readings))
+				for name, rd := range state.readings {
+					readings[name] = rd
+				}
 				resultlock.Unlock()
 
-				// Local screen to use
-				image_max_x := screen.Cols()
-
-				var silo, hatchgreen,
-					ok_button,
-					close_button,
-					watch_ad_boosts_button,
-					watch_ad_round_offer_round_icon_button,
-					boost_button,
-					chickenbutton,
-					red_dont_watch_ad_button,
-					green_watch_ad_button,
-					package_icon image.Point
-
-				var max_chickens,
-					ad_offer_accept,
-					ad_offer_reject,
-					boost_active_soul_mirror,
-					video_double_indicator bool
-
-				stillprocessing := true
+				seen := make(map[string]image.Point)
+				scores := make(map[string]float32)
 				for _, res := range results {
-					if res.confidence < res.threshold && stillprocessing {
-						switch res.name {
-						case "silo":
-							silo = res.location
-						case "daily_reward":
-							// ignore
-						case "boosts_button":
-							boost_button = res.location
-						case "video_double_indicator":
-							video_double_indicator = true
-						case "boost_active_soul_mirror":
-							boost_active_soul_mirror = true
-						case "boosts_watch_ad":
-							watch_ad_boosts_button = res.location
-						case "watch_ad":
-							watch_ad_round_offer_round_icon_button = res.location
-						case "ad_offer_boost", "ad_offer_eggs", "ad_offer_box_of_eggs", "ad_offer_crate_of_eggs", "ad_offer_chicken_box", "ad_offer_large_chicken_box", "ad_offer_tickets":
-							ad_offer_accept = true
-						case "ad_offer_money", "ad_offer_a_ton_of_cash":
-							ad_offer_reject = true
-						case "ad_offer_no_thanks_button":
-							red_dont_watch_ad_button = res.location
-						case "ad_offer_watch_button":
-							green_watch_ad_button = res.location
-						case "blue_close_button",
-							"green_close_button",
-							"purple_close_button",
-							"red_close_button":
-							close_button = res.location
-						case "lightblue_ok_button",
-							"blue_ok_button",
-							"pink_ok_button",
-							"purple_ok_button",
-							"grey_ok_button":
-							ok_button = res.location
-							/*					case "green_research_button":
-												fmt.Println("Auto researching 10x")
-												e.Click(res.location.X, res.location.Y, 10)*/
-						case "drone_1", "drone_2":
-							// fmt.Println("Drone detected")
-							// e.Click(middle.X, middle.Y, 1)
-						case "launchicon", "launchicon_hat", "launchicon_hat_2":
-							fmt.Println("Launching app")
-							e.Click(scale_pos(res.location), 1)
-							time.Sleep(time.Millisecond * 4000)
-							shoot_drones = true
-							stillprocessing = false
-						case "mission_returned":
-							fmt.Println("Mission returned")
-							e.Click(scale_pos(res.location), 1)
-							time.Sleep(time.Millisecond * 2000)
-							stillprocessing = false
-						case "collect_and_refill_silos_button",
-							"collect_button",
-							"purple_collect_button":
-							fmt.Println("Collect button")
-							e.Click(scale_pos(res.location), 1)
-							time.Sleep(time.Millisecond * 500)
-							stillprocessing = false
-						case "max_chicken_running_bonus":
-							max_chickens = true
-						case "chickenbutton":
-							chickenbutton = res.location
-							shoot_drones = true
-							lastoktime = time.Now()
-						case "hatch_green":
-							hatchgreen = res.location
-						// case "daily_reward":
-						// 	fmt.Printf("Daily reward at %v, %v\n", res.location.X, res.location.Y)
-						// 	e.Click(res.location.X, res.location.Y, 1)
-						// 	break templateloop
-						case "package":
-							package_icon = res.location
-						default:
-							fmt.Printf("Unknown detector %s\n", res.name)
-						}
+					if res.confidence < res.threshold {
+						seen[res.name] = res.location
+						scores[res.name] = res.confidence / res.threshold
 					}
 				}
 
+				if current == screenFarmMain {
+					shoot_drones = true
+					lastoktime = time.Now()
+					profiles.switchto(farmtype(seen), engine, boosts, hatch)
+				}
+				profiles.goalreadings(readings)
+
 				// Blur detection
-				if !watching_ad && time.Duration(boost_button.X) > 0 && time.Since(lastblurtime) > time.Second*15 {
+				if boost_button, found := seen["boosts_button"]; found && !watching_ad && current == screenFarmMain && time.Since(lastblurtime) > time.Second*15 {
 					greymat := gocv.NewMat()
 					gocv.CvtColor(screen, &greymat, gocv.ColorRGBToGray)
 					lap := gocv.NewMat()
@@ -572,116 +893,40 @@ func main() {
 				}
 
 				// take action
-				if watching_ad {
-					// Figure out if we're done watching ad
-					if chickenbutton.X != 0 {
-						fmt.Println("Ad complete")
-						lastdronetime = time.Now()
-						lastoktime = time.Now()
-						watching_ad = false
-						shoot_drones = true
-					} else if time.Since(ad_started) > time.Second*45 {
-						fmt.Println("Ad timed out/finished")
-						e.SendKey(uintptr(e.Config.Home), 1)
-
-						watching_ad = false
-						shoot_drones = true
-						lastdronetime = time.Now()
-						lastoktime = time.Now()
-					}
-					time.Sleep(time.Second)
-				} else if time.Since(lastoktime) > time.Second*60 || time.Since(lastdronetime) > time.Second*120 {
-					fmt.Println("Crash detected, restarting app")
-
-					fmt.Println("Clearning app task list")
-					for i := 0; i < 3; i++ {
-						e.SendKey(e.Config.AppSwitcher, 1)
-						time.Sleep(time.Millisecond * 500)
-						e.SendKey(win.VK_DELETE, 1)
-						time.Sleep(time.Millisecond * 500)
-						e.SendKey(e.Config.Escape, 1)
-						time.Sleep(time.Millisecond * 500)
-					}
-					e.SendKey(e.Config.Home, 1)
-
-					lastdronetime = time.Now()
-					lastoktime = time.Now()
-				} else if !boost_active_soul_mirror && green_watch_ad_button.X > 0 && red_dont_watch_ad_button.X > 0 {
-					if ad_offer_reject {
-						fmt.Println("Rejecting advertisement")
-						e.Click(scale_pos(red_dont_watch_ad_button), 1)
-						time.Sleep(time.Millisecond * 500)
-					} else {
-						if ad_offer_accept {
-							fmt.Println("Accepting to watch advertisement")
-						} else {
-							fmt.Println("Unknown advertisement type, save screenshot ??? ... accepting it though")
-							time.Sleep(time.Second * 5)
-						}
-
-						e.Click(scale_pos(green_watch_ad_button), 1)
-						shoot_drones = false
-						watching_ad = true
-						ad_started = time.Now()
-						time.Sleep(time.Second * 3)
-					}
-					lastoktime = time.Now()
-				} else if !boost_active_soul_mirror && watch_ad_boosts_button.X > 0 {
-					// Accept 2x boost from dialog
-					fmt.Printf("Watching ad for boosts at %v, %v\n", watch_ad_boosts_button.X, watch_ad_boosts_button.Y)
-					e.Click(scale_pos(watch_ad_boosts_button), 1)
-					shoot_drones = false
-					watching_ad = true
-					ad_started = time.Now()
-					lastoktime = time.Now()
-					time.Sleep(time.Second * 3)
-				} else if ok_button.X > 0 {
-					fmt.Printf("Acknowleging dialog at %v, %v\n", ok_button.X, ok_button.Y)
-					e.Click(scale_pos(ok_button), 1)
-					time.Sleep(time.Millisecond * 1000)
-					lastoktime = time.Now()
-				} else if close_button.X > 0 {
-					fmt.Printf("Closing dialog at %v, %v\n", close_button.X, close_button.Y)
-					e.Click(scale_pos(close_button), 1)
-					time.Sleep(time.Millisecond * 1000)
-					lastoktime = time.Now()
-				} else if !boost_active_soul_mirror && !video_double_indicator && boost_button.X > 0 && time.Since(last_double_video_boosts_open) > time.Minute*15 {
-					fmt.Println("Opening boosts dialogue to get double video")
-					last_double_video_boosts_open = time.Now()
-					e.Click(scale_pos(boost_button), 1)
-					time.Sleep(time.Millisecond * 1000) // Wait for dialog to settle
-				} else if !boost_active_soul_mirror && watch_ad_round_offer_round_icon_button.X > image_max_x*7/10 && green_watch_ad_button.X == 0 {
-					if last_ad_icon != watch_ad_round_offer_round_icon_button {
-						// Needs to settle
-						last_ad_icon = watch_ad_round_offer_round_icon_button
-					} else {
-						// Open dialog to possibly watch ad
-						fmt.Println("Checking out ad offer")
-						e.Click(scale_pos(watch_ad_round_offer_round_icon_button), 1)
-						last_ad_icon = image.ZP
-					}
-				} else if package_icon.X > 0 {
-					if last_package_icon != package_icon {
-						// Needs to settle down
-						last_package_icon = package_icon
-					} else {
-						fmt.Printf("Grabbing package at %v, %v\n", package_icon.X, package_icon.Y)
-						e.Click(scale_pos(package_icon), 1)
-						last_package_icon = image.ZP
-					}
-				} else if !max_chickens && hatchgreen.X > 0 && chickenbutton.X > 0 {
-					fmt.Println("Hatching a lot of chickens")
-					e.Click(scale_pos(chickenbutton), 250)
-				} else if silo.X == 0 && chickenbutton.X > 0 {
-					// Move screen so silo is visible
-					fmt.Println("Moving screen to silo")
-					middle := scale_pos(image.Pt(screen.Cols()/2, screen.Rows()/2))
-					e.MouseDown(middle)
-					for i := 0; i < 10; i++ {
-						time.Sleep(time.Millisecond * 3)
-						e.MouseDrag(middle.Add(image.Pt(i*3, i*3)))
+				ctx := rulecontext{
+					now:    time.Now(),
+					screen: current,
+					size:   image.Pt(screen.Cols(), screen.Rows()),
+					seen:   seen,
+					scores: scores,
+					flags: map[string]bool{
+						"watching_ad": watching_ad,
+						"habs_full":   time.Since(hatch.habsfull) < hatch.recheck,
+						"contract":    profiles.farm == "contract",
+					},
+					timers: map[string]time.Time{
+						"lastok":       lastoktime,
+						"lastdrone":    lastdronetime,
+						"ad_started":   ad_started,
+						"missions_due": missions.due,
+					},
+					readings: readings,
+				}
+				ctx.flags["boost_due"] = boosts.due(&ctx, engine) != nil
+				ctx.flags["daily_due"] = daily.due(seen, ctx.now) != nil
+				ctx.flags["daily_pending"] = daily.pending != nil
+				ctx.flags["daily_reward_dialog"] = daily.rewarddialog(seen)
+
+				// Scripts go first, so they can turn rules on and off before they're evaluated
+				acted := scripts.frame(&ctx, scriptscreen)
+				scriptscreen = current
+
+				// If a script acted this screen is gone, rules wait for the next one
+				if !acted {
+					if r, target := engine.evaluate(&ctx); r != nil {
+						actions[r.Action](r, &ctx, target)
+						time.Sleep(time.Duration(r.Settle))
 					}
-					e.MouseUp(middle.Add(image.Pt(30, 30)))
 				}
 
 				screen.Close()
@@ -698,7 +943,16 @@ func main() {
 
 	var lastdebugimagetime time.Time
 	var last_rect image.Rectangle
+	laststatstime := time.Now()
 	for running {
+		if time.Since(laststatstime) > time.Minute {
+			fmt.Printf("Stats: %v\nHit rate by screen region:\n%v", botstats, botstats.accuracy())
+			if len(gestures) > 1 {
+				fmt.Printf("Hit rate by gesture: %v\n", botstats.bygesture())
+			}
+			laststatstime = time.Now()
+		}
+
 		r, err := e.Rect()
 		if err != nil {
 			panic(err)
@@ -726,14 +980,25 @@ func main() {
 			resultlock.Lock()
 			debugresults := make([]result, len(lastresults))
 			copy(debugresults, lastresults)
-			droneresults := make([]takedowninfo, len(lastdronetakedowns))
-			copy(droneresults, lastdronetakedowns)
+			droneresults := lastdronetracks
+			debugscreen, debugscreenconfidence := state.screen, state.screenconfidence
+			debugreadings := make(map[string]reading, len(state.readings))
+			for name, rd := range state.readings {
+				debugreadings[name] = rd
+			}
 			debugmat := lastimage.Clone()
 			resultlock.Unlock()
 
 			// Resize debug window if needed
 
 			// Draw debug window
+			for _, dm := range dp.Masks {
+				if dm.active(debugscreen) {
+					pv := gocv.NewPointsVectorFromPoints([][]image.Point{dm.points(image.Pt(debugmat.Cols(), debugmat.Rows()))})
+					gocv.Polylines(&debugmat, pv, true, color.RGBA{255, 0, 255, 0}, 1)
+					pv.Close()
+				}
+			}
 			for _, result := range debugresults {
 				var col color.RGBA
 				var show bool
@@ -750,16 +1015,25 @@ func main() {
 				}
 			}
 
-			for _, ti := range droneresults {
-				for i, pos := range ti.positions {
+			for _, tr := range droneresults {
+				trackcolor := color.RGBA{0, 0, 255, 0}
+				if tr.state == trackTentative {
+					trackcolor = color.RGBA{128, 128, 128, 0}
+				}
+				for i, pos := range tr.positions {
 					if i == 0 {
 						gocv.Circle(&debugmat, pos, 5, color.RGBA{0, 128, 255, 0}, -1)
 					} else {
-						gocv.Circle(&debugmat, pos, 5, color.RGBA{0, 0, 255, 0}, -1)
+						gocv.Circle(&debugmat, pos, 5, trackcolor, -1)
 					}
-
 				}
-				for _, zap := range ti.zaps {
+				last := tr.positions[len(tr.positions)-1]
+				label := fmt.Sprintf("#%v", tr.id)
+				if tr.elite() {
+					label += " elite"
+				}
+				gocv.PutText(&debugmat, label, last.Add(image.Pt(8, -8)), gocv.FontHersheyPlain, 1, trackcolor, 2)
+				for _, zap := range tr.zaps {
 					gocv.Circle(&debugmat, zap.position, 5, color.RGBA{255, 0, 0, 0}, -1)
 					if zap.position != zap.predicted {
 						gocv.Circle(&debugmat, zap.predicted, 5, color.RGBA{255, 64, 192, 0}, -1)
@@ -767,6 +1041,20 @@ func main() {
 				}
 			}
 
+			for _, region := range ocrregions {
+				rd, found := debugreadings[region.name]
+				if !found || time.Since(rd.time) > time.Second*5 {
+					continue
+				}
+				rect := region.rect(debugmat)
+				col := color.RGBA{255, 255, 0, 0}
+				gocv.Rectangle(&debugmat, rect, col, 1)
+				gocv.PutText(&debugmat, fmt.Sprintf("%v %.0f (%.2f)", region.name, rd.value, rd.confidence), image.Pt(rect.Min.X, rect.Max.Y+12), gocv.FontHersheyPlain, 1, col, 1)
+			}
+
+			gocv.PutText(&debugmat, fmt.Sprintf("%v %.2f", debugscreen, debugscreenconfidence), image.Pt(4, debugmat.Rows()-8), gocv.FontHersheyPlain, 1.5, color.RGBA{255, 255, 255, 0}, 2)
+			gocv.PutText(&debugmat, botstats.String(), image.Pt(4, debugmat.Rows()-30), gocv.FontHersheyPlain, 1, color.RGBA{255, 255, 255, 0}, 1)
+
 			window.IMShow(debugmat)
 			if window.WaitKey(5) == 27 {
 				// signal stop
@@ -778,4 +1066,5 @@ func main() {
 
 		time.Sleep(time.Millisecond * 25)
 	}
+	<-dronesdone
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// missionconfig is which missions to send rockets on. Ships, durations and
+// artifacts are picked by clicking their templates, named ship_<ship>,
+// duration_<duration> and target_<artifact> with spaces as underscores
+type missionconfig struct {
+	Enabled  bool         `json:"enabled"`
+	Ship     string       `json:"ship"`     // chicken one, chicken nine, heavy ... henerprise
+	Duration string       `json:"duration"` // short, standard or extended
+	Target   string       `json:"target"`   // artifact to aim for, blank for any
+	Slots    int          `json:"slots"`    // missions that can fly at once
+	Timers   [][4]float64 `json:"timers"`   // where the mission timers are in the hangar, fractions of the screen
+	Recheck  duration     `json:"recheck"`  // when to look again if no timer could be read
+
+	// Buttons in the hangar
+	LaunchButton  string `json:"launch_button"`  // on an empty slot
+	ConfirmButton string `json:"confirm_button"` // launches the chosen mission
+	NoFuel        string `json:"no_fuel"`        // shown when the tank can't fuel the mission
+
+	due         time.Time // when the next mission returns
+	collectonly bool      // templates to launch with are missing
+}
+
+var defaultmissions = missionconfig{
+	Enabled:  true,
+	Ship:     "chicken one",
+	Duration: "standard",
+	Slots:    3,
+	Timers: [][4]float64{
+		{0.55, 0.30, 0.85, 0.33},
+		{0.55, 0.47, 0.85, 0.50},
+		{0.55, 0.64, 0.85, 0.67},
+	},
+	Recheck:       duration(time.Minute * 30),
+	LaunchButton:  "mission_launch_button",
+	ConfirmButton: "mission_confirm_button",
+	NoFuel:        "mission_not_enough_fuel",
+}
+
+func loadmissions(filename string) (*missionconfig, error) {
+	mc := defaultmissions
+	if _, err := loadjson(filename, &mc); err != nil {
+		return nil, err
+	}
+	switch mc.Duration {
+	case "short", "standard", "extended":
+	default:
+		return nil, fmt.Errorf("unknown mission duration %q", mc.Duration)
+	}
+	return &mc, nil
+}
+
+// check looks for the templates launching needs, which aren't shipped.
+// Without them missions are only collected when they return
+func (mc *missionconfig) check(templates map[string]*template) {
+	for _, name := range append(mc.choices(), mc.LaunchButton, mc.ConfirmButton) {
+		if _, found := templates[name]; !found {
+			fmt.Printf("No template for %v, only collecting missions when they return\n", name)
+			mc.collectonly = true
+			return
+		}
+	}
+}
+
+func templatename(prefix, name string) string {
+	return prefix + strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+}
+
+// choices is the templates clicked to set up a mission, in order
+func (mc *missionconfig) choices() []string {
+	names := []string{templatename("ship_", mc.Ship), templatename("duration_", mc.Duration)}
+	if mc.Target != "" {
+		names = append(names, templatename("target_", mc.Target))
+	}
+	return names
+}
+
+// collect clicks through returned missions and the artifacts they brought
+func (mc *missionconfig) collect(s *session, inv *inventory) int {
+	collected := 0
+	for i := 0; i < 20; i++ {
+		switch {
+		case s.clickon("collect_mission_button", time.Second*2):
+			collected++
+		case inv.collect(s):
+		default:
+			return collected
+		}
+	}
+	return collected
+}
+
+// launch sends off one mission, it's false when it couldn't
+func (mc *missionconfig) launch(s *session) bool {
+	if !s.clickon(mc.LaunchButton, time.Second*2) {
+		return false
+	}
+	for _, name := range mc.choices() {
+		if !s.clickon(name, time.Second) {
+			fmt.Printf("Can't find %v to set up the mission\n", name)
+			s.leave()
+			return false
+		}
+	}
+
+	mat := s.capture()
+	_, nofuel := s.find(mat, mc.NoFuel)
+	mat.Close()
+	if nofuel {
+		fmt.Printf("Not enough fuel for a %v %v mission\n", mc.Duration, mc.Ship)
+		s.leave()
+		return false
+	}
+	if !s.clickon(mc.ConfirmButton, time.Second*3) {
+		fmt.Println("Can't find the button to launch the mission")
+		s.leave()
+		return false
+	}
+	fmt.Printf("Launched a %v %v mission\n", mc.Duration, mc.Ship)
+	return true
+}
+
+// nextreturn reads the mission timers and returns when the first mission is back
+func (mc *missionconfig) nextreturn(s *session, mat gocv.Mat) (time.Duration, bool) {
+	var first time.Duration
+	found := false
+	for _, area := range mc.Timers {
+		region := ocrregion{name: "mission_timer", kind: readingDuration, area: area}
+		rd := s.reader.readregion(mat, region)
+		if rd.confidence == 0 {
+			continue
+		}
+		left := time.Duration(rd.value * float64(time.Second))
+		if !found || left < first {
+			first, found = left, true
+		}
+	}
+	return first, found
+}
+
+// missions opens the hangar from the farm, collects returned missions,
+// launches new ones into the empty slots and works out when to come back.
+// Going back to the farm checks the screen, so a launch that already backed
+// out doesn't make it go back too far
+func (mc *missionconfig) missions(s *session, inv *inventory) {
+	if !mc.Enabled {
+		mc.due = time.Now().Add(time.Hour * 24)
+		return
+	}
+	mc.due = time.Now().Add(time.Duration(mc.Recheck))
+	if mc.collectonly {
+		// Nothing to launch, so the hangar is only worth a visit when a mission is back
+		mc.due = time.Now().Add(time.Hour * 24)
+	}
+	// A returned mission notice opens the hangar too
+	if !s.clickon("mission_returned", time.Second*2) && (mc.collectonly || !s.clickon("rocket_base", time.Second*2)) {
+		fmt.Println("Can't find the hangar")
+		return
+	}
+	if current := s.screen(); current != screenMissionScreen {
+		fmt.Printf("Expected the hangar but the screen is %v\n", current)
+		s.backtofarm()
+		return
+	}
+	defer s.backtofarm()
+
+	if collected := mc.collect(s, inv); collected > 0 {
+		fmt.Printf("Collected %v missions\n", collected)
+	}
+
+	if mc.collectonly {
+		return
+	}
+	for launched := 0; launched < mc.Slots; launched++ {
+		if !mc.launch(s) {
+			break
+		}
+	}
+
+	if !s.reader.enabled() {
+		return
+	}
+	mat := s.capture()
+	left, found := mc.nextreturn(s, mat)
+	mat.Close()
+	if found {
+		mc.due = time.Now().Add(left)
+		fmt.Printf("Next mission returns in %v\n", left)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// motiondetector finds what moves on the farm, which stays put on screen
+// unless a dialog opens or the bot shakes it. Only moving areas are checked
+// for drone colours, and places where a drone is found again and again
+// without it going anywhere are props and ignored until the view moves
+type motiondetector struct {
+	mog2   gocv.BackgroundSubtractorMOG2
+	frames int
+
+	warmup        int     // frames before the background is trusted
+	maxforeground float64 // fraction of the screen moving that means the whole view changed
+	cell          int     // pixels per cell when remembering props
+	ignoreafter   int     // still sightings in a cell before it's a prop
+
+	still   map[image.Point]int
+	ignored map[image.Point]bool
+}
+
+func newmotiondetector(screenwidth int) *motiondetector {
+	return &motiondetector{
+		mog2:          gocv.NewBackgroundSubtractorMOG2WithParams(150, 25, false),
+		warmup:        30,
+		maxforeground: 0.25,
+		cell:          screenwidth / 27,
+		ignoreafter:   30,
+		still:         make(map[image.Point]int),
+		ignored:       make(map[image.Point]bool),
+	}
+}
+
+func (md *motiondetector) close() {
+	if md != nil {
+		md.mog2.Close()
+	}
+}
+
+// moving returns the areas that differ from the background, or false if the
+// background isn't known or the whole view changed
+func (md *motiondetector) moving(mat gocv.Mat, kernel gocv.Mat) ([]image.Rectangle, bool) {
+	foreground := gocv.NewMat()
+	defer foreground.Close()
+	md.mog2.Apply(mat, &foreground)
+	md.frames++
+	if md.frames < md.warmup {
+		return nil, false
+	}
+	if float64(gocv.CountNonZero(foreground)) > md.maxforeground*float64(mat.Rows()*mat.Cols()) {
+		// The props aren't where they were any more
+		md.forget()
+		return nil, false
+	}
+
+	dilated := gocv.NewMat()
+	gocv.Dilate(foreground, &dilated, kernel)
+	contours := gocv.FindContours(dilated, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	dilated.Close()
+	var rects []image.Rectangle
+	for i := 0; i < contours.Size(); i++ {
+		rects = append(rects, gocv.BoundingRect(contours.At(i)))
+	}
+	contours.Close()
+	return rects, true
+}
+
+// detect finds drones among the moving areas, or all over the screen when
+// motion can't be trusted or isn't used. If focus is set, only those parts of
+// the screen are looked at
+func (md *motiondetector) detect(mat gocv.Mat, dp droneprofile, kernel gocv.Mat, focus []image.Rectangle) []dronedetection {
+	areas := focus
+	if md != nil {
+		if rects, ok := md.moving(mat, kernel); ok {
+			// Grow the moving areas to hold a whole drone, as only part of it may
+			// have changed
+			margin := int(math.Sqrt(float64(dp.BlackArea[1])))
+			areas = nil
+			for _, r := range rects {
+				if r.Dx()*r.Dy() < dp.BrownArea[0] {
+					continue
+				}
+				if focus != nil && !overlapsany(r, focus) {
+					continue
+				}
+				areas = append(areas, r.Inset(-margin))
+			}
+			if areas == nil {
+				return nil
+			}
+		}
+	}
+
+	var detections []dronedetection
+	if areas == nil {
+		detections = detectdrones(mat, dp, kernel)
+	}
+	for _, area := range mergerects(areas) {
+		detections = append(detections, detectdronesin(mat, area, dp, kernel)...)
+	}
+	if md == nil {
+		return detections
+	}
+
+	var result []dronedetection
+	for _, dd := range detections {
+		if !md.ignored[md.cellof(dd.position)] {
+			result = append(result, dd)
+		}
+	}
+	return result
+}
+
+// mergerects joins overlapping rectangles until none overlap
+func mergerects(rects []image.Rectangle) []image.Rectangle {
+	var merged []image.Rectangle
+	for _, r := range rects {
+		for again := true; again; {
+			again = false
+			for i, other := range merged {
+				if r.Overlaps(other) {
+					r = r.Union(other)
+					merged = append(merged[:i], merged[i+1:]...)
+					again = true
+					break
+				}
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func overlapsany(r image.Rectangle, rects []image.Rectangle) bool {
+	for _, other := range rects {
+		if r.Overlaps(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func (md *motiondetector) cellof(p image.Point) image.Point {
+	return image.Pt(p.X/md.cell, p.Y/md.cell)
+}
+
+// stillat notes a drone sighting that hasn't gone anywhere
+func (md *motiondetector) stillat(p image.Point) {
+	if md == nil {
+		return
+	}
+	c := md.cellof(p)
+	md.still[c]++
+	if md.still[c] == md.ignoreafter {
+		fmt.Printf("Ignoring drone look-alike at %v from now on\n", p)
+		md.ignored[c] = true
+	}
+}
+
+// forget drops the props found so far, for when the camera has moved
+func (md *motiondetector) forget() {
+	if md == nil || len(md.still) == 0 {
+		return
+	}
+	if len(md.ignored) > 0 {
+		fmt.Printf("View moved, no longer ignoring %v drone look-alikes\n", len(md.ignored))
+	}
+	md.still = make(map[image.Point]int)
+	md.ignored = make(map[image.Point]bool)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gocv.io/x/gocv"
+)
+
+// Glyph templates are named glyph_<id>.<height>.png, where id is a digit,
+// one of the names below, or upper_X / lower_x for letters (Windows can't
+// tell glyph_q from glyph_Q)
+var glyphnames = map[string]rune{
+	"dot":     '.',
+	"comma":   ',',
+	"colon":   ':',
+	"percent": '%',
+	"dollar":  '$',
+	"slash":   '/',
+}
+
+func glyphrune(id string) (rune, bool) {
+	if len(id) == 1 && id[0] >= '0' && id[0] <= '9' {
+		return rune(id[0]), true
+	}
+	if r, found := glyphnames[id]; found {
+		return r, true
+	}
+	if strings.HasPrefix(id, "upper_") && len(id) == 7 {
+		return unicode.ToUpper(rune(id[6])), true
+	}
+	if strings.HasPrefix(id, "lower_") && len(id) == 7 {
+		return unicode.ToLower(rune(id[6])), true
+	}
+	return 0, false
+}
+
+type glyph struct {
+	char rune
+	*template
+}
+
+type ocr struct {
+	glyphs []glyph
+}
+
+func (o *ocr) add(name string, t *template) bool {
+	id := strings.TrimPrefix(name, "glyph_")
+	if id == name {
+		return false
+	}
+	r, found := glyphrune(id)
+	if !found {
+		fmt.Printf("Unknown glyph %v, ignoring it\n", name)
+		return false
+	}
+	o.glyphs = append(o.glyphs, glyph{char: r, template: t})
+	return true
+}
+
+func (o *ocr) enabled() bool {
+	return len(o.glyphs) > 0
+}
+
+type glyphhit struct {
+	char      rune
+	rect      image.Rectangle
+	score     float32
+	threshold float32
+}
+
+// read matches every glyph inside rect and returns the text left to right,
+// along with a 0-1 confidence (1 is a perfect match on every character)
+func (o *ocr) read(screen gocv.Mat, rect image.Rectangle) (string, float32) {
+	rect = rect.Intersect(image.Rect(0, 0, screen.Cols(), screen.Rows()))
+	if rect.Empty() {
+		return "", 0
+	}
+
+	crop := screen.Region(rect)
+	defer crop.Close()
+
+	var hits []glyphhit
+	for _, g := range o.glyphs {
+		if g.mat.Cols() > crop.Cols() || g.mat.Rows() > crop.Rows() {
+			continue
+		}
+		resultmat := gocv.NewMat()
+		gocv.MatchTemplate(crop, g.mat, &resultmat, gocv.TmSqdiffNormed, g.mask)
+		for y := 0; y < resultmat.Rows(); y++ {
+			for x := 0; x < resultmat.Cols(); x++ {
+				score := resultmat.GetFloatAt(y, x)
+				if score < g.threshold {
+					hits = append(hits, glyphhit{
+						char:      g.char,
+						rect:      image.Rect(x, y, x+g.mat.Cols(), y+g.mat.Rows()),
+						score:     score,
+						threshold: g.threshold,
+					})
+				}
+			}
+		}
+		resultmat.Close()
+	}
+
+	// Best matches first, then drop anything overlapping an already accepted glyph
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].score < hits[j].score
+	})
+	var accepted []glyphhit
+	for _, hit := range hits {
+		overlapping := false
+		for _, a := range accepted {
+			overlap := hit.rect.Intersect(a.rect).Dx()
+			narrowest := hit.rect.Dx()
+			if a.rect.Dx() < narrowest {
+				narrowest = a.rect.Dx()
+			}
+			if overlap*2 > narrowest {
+				overlapping = true
+				break
+			}
+		}
+		if !overlapping {
+			accepted = append(accepted, hit)
+		}
+	}
+	if len(accepted) == 0 {
+		return "", 0
+	}
+
+	// Everything on the line shares a baseline, so stray hits above or below are noise
+	sort.Slice(accepted, func(i, j int) bool {
+		return accepted[i].rect.Max.Y < accepted[j].rect.Max.Y
+	})
+	baseline := accepted[len(accepted)/2].rect.Max.Y
+	var line []glyphhit
+	for _, hit := range accepted {
+		if abs(hit.rect.Max.Y-baseline)*2 <= hit.rect.Dy() {
+			line = append(line, hit)
+		}
+	}
+
+	sort.Slice(line, func(i, j int) bool {
+		return line[i].rect.Min.X < line[j].rect.Min.X
+	})
+
+	var text strings.Builder
+	var confidence float32
+	for _, hit := range line {
+		text.WriteRune(hit.char)
+		confidence += hit.score / hit.threshold
+	}
+	confidence = 1 - confidence/float32(len(line))
+	if confidence < 0 {
+		confidence = 0
+	}
+
+	return text.String(), confidence
+}
+
+// Egg Inc suffixes, each one a factor 1000 above the previous
+var numbersuffixes = []string{"", "K", "M", "B", "T", "q", "Q", "s", "S", "o", "N", "d", "U", "D",
+	"Td", "qd", "Qd", "sd", "Sd", "Od", "Nd", "V", "uV", "dV", "tV", "qV", "QV", "sV", "SV", "OV", "NV", "tT"}
+
+// parsenumber understands the in-game number formats: "1,234", "$12.5M", "3.21Qd"
+func parsenumber(text string) (float64, error) {
+	text = strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '$', ' ':
+			return -1
+		}
+		return r
+	}, text)
+
+	split := strings.IndexFunc(text, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, suffix := text, ""
+	if split != -1 {
+		number, suffix = text[:split], text[split:]
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %q: %v", text, err)
+	}
+
+	multiplier := 1.0
+	for _, s := range numbersuffixes {
+		if s == suffix {
+			return value * multiplier, nil
+		}
+		multiplier *= 1000
+	}
+	return 0, fmt.Errorf("parsing %q: unknown suffix %q", text, suffix)
+}
+
+// parseduration understands timers like "1h 23m", "2d4h", "45s" and "12:05"
+func parseduration(text string) (time.Duration, error) {
+	text = strings.ReplaceAll(text, " ", "")
+	if text == "" {
+		return 0, fmt.Errorf("parsing empty duration")
+	}
+
+	if strings.Contains(text, ":") {
+		var d time.Duration
+		for _, part := range strings.Split(text, ":") {
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("parsing %q: %v", text, err)
+			}
+			d = d*60 + time.Duration(v)
+		}
+		return d * time.Second, nil
+	}
+
+	units := map[rune]time.Duration{
+		'd': time.Hour * 24,
+		'h': time.Hour,
+		'm': time.Minute,
+		's': time.Second,
+	}
+	var d time.Duration
+	var v int
+	var digits bool
+	for _, r := range text {
+		if r >= '0' && r <= '9' {
+			v = v*10 + int(r-'0')
+			digits = true
+			continue
+		}
+		unit, found := units[r]
+		if !found || !digits {
+			return 0, fmt.Errorf("parsing %q: unexpected %q", text, r)
+		}
+		d += time.Duration(v) * unit
+		v, digits = 0, false
+	}
+	if digits {
+		return 0, fmt.Errorf("parsing %q: missing unit", text)
+	}
+	return d, nil
+}
+
+// splitprogress understands "delivered/goal" like "1.2q/5q"
+func splitprogress(text string) (float64, float64, error) {
+	done, goal, found := strings.Cut(text, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("parsing %q: no goal", text)
+	}
+	d, err := parsenumber(done)
+	if err != nil {
+		return 0, 0, err
+	}
+	g, err := parsenumber(goal)
+	if err != nil {
+		return 0, 0, err
+	}
+	if g == 0 {
+		return 0, 0, fmt.Errorf("parsing %q: zero goal", text)
+	}
+	return d, g, nil
+}
+
+// parseprogress is "delivered/goal" as a fraction
+func parseprogress(text string) (float64, error) {
+	d, g, err := splitprogress(text)
+	if err != nil {
+		return 0, err
+	}
+	return d / g, nil
+}
+
+type readingkind int
+
+const (
+	readingNumber readingkind = iota
+	readingDuration
+	readingProgress // "1.2q/5q", read as the fraction done
+)
+
+// ocrregion is a place on the screen where a counter is shown. Coordinates
+// are fractions of the screen, measured on the 1080x1920 portrait layout
+type ocrregion struct {
+	name     string
+	kind     readingkind
+	requires string // template that has to be visible for the counter to be on screen, blank if only read on demand
+	area     [4]float64
+}
+
+var ocrregions = []ocrregion{
+	{name: "cash", kind: readingNumber, requires: "chickenbutton", area: [4]float64{0.30, 0.045, 0.75, 0.085}},
+	{name: "golden_eggs", kind: readingNumber, requires: "chickenbutton", area: [4]float64{0.08, 0.015, 0.30, 0.045}},
+	{name: "soul_eggs", kind: readingNumber, requires: "chickenbutton", area: [4]float64{0.08, 0.045, 0.30, 0.075}},
+	{name: "chickens", kind: readingNumber, requires: "chickenbutton", area: [4]float64{0.60, 0.86, 0.90, 0.89}},
+	{name: "farm_value", kind: readingNumber, requires: "", area: [4]float64{0.35, 0.30, 0.90, 0.34}},
+	{name: "boost_timer", kind: readingDuration, requires: "boosts_watch_ad", area: [4]float64{0.62, 0.26, 0.92, 0.29}},
+	{name: "contract_progress", kind: readingProgress, requires: "contract_indicator", area: [4]float64{0.20, 0.135, 0.80, 0.16}},
+	{name: "contract_time_left", kind: readingDuration, requires: "contract_indicator", area: [4]float64{0.20, 0.16, 0.80, 0.185}},
+	// The goals listed in the contract's info, opened from the indicator
+	{name: "contract_goal_1", kind: readingNumber, requires: "contract_goals", area: [4]float64{0.55, 0.42, 0.85, 0.45}},
+	{name: "contract_goal_2", kind: readingNumber, requires: "contract_goals", area: [4]float64{0.55, 0.50, 0.85, 0.53}},
+	{name: "contract_goal_3", kind: readingNumber, requires: "contract_goals", area: [4]float64{0.55, 0.58, 0.85, 0.61}},
+}
+
+// namedregion finds a counter's region by name
+func namedregion(name string) (ocrregion, bool) {
+	for _, region := range ocrregions {
+		if region.name == name {
+			return region, true
+		}
+	}
+	return ocrregion{}, false
+}
+
+// readableregions leaves out the counters whose template isn't loaded, as
+// they'd never be on screen
+func readableregions(regions []ocrregion, templates map[string]*template) []ocrregion {
+	var readable []ocrregion
+	for _, region := range regions {
+		if _, found := templates[region.requires]; region.requires != "" && !found {
+			fmt.Printf("No %v template, not reading %v\n", region.requires, region.name)
+			continue
+		}
+		readable = append(readable, region)
+	}
+	return readable
+}
+
+func (r ocrregion) rect(screen gocv.Mat) image.Rectangle {
+	return image.Rect(
+		int(r.area[0]*float64(screen.Cols())),
+		int(r.area[1]*float64(screen.Rows())),
+		int(r.area[2]*float64(screen.Cols())),
+		int(r.area[3]*float64(screen.Rows())),
+	)
+}
+
+// readregion reads and parses a counter. A reading that can't be parsed is
+// returned with zero confidence
+func (o *ocr) readregion(screen gocv.Mat, region ocrregion) reading {
+	text, confidence := o.read(screen, region.rect(screen))
+	rd := reading{
+		text:       text,
+		confidence: confidence,
+		time:       time.Now(),
+	}
+
+	var err error
+	switch region.kind {
+	case readingNumber:
+		rd.value, err = parsenumber(text)
+	case readingDuration:
+		var d time.Duration
+		d, err = parseduration(text)
+		rd.value = d.Seconds()
+	case readingProgress:
+		rd.value, err = parseprogress(text)
+	}
+	if err != nil {
+		rd.confidence = 0
+	}
+	return rd
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
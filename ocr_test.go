@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// close enough for numbers built up by repeated multiplication
+func nearly(a, b float64) bool {
+	return math.Abs(a-b) <= math.Abs(b)*1e-9
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		text  string
+		value float64
+		fails bool
+	}{
+		{text: "42", value: 42},
+		{text: "1,234", value: 1234},
+		{text: "$12.5M", value: 12.5e6},
+		{text: "0.5K", value: 500},
+		{text: "1.5 B", value: 1.5e9},
+		{text: "7q", value: 7e15},
+		{text: "7Q", value: 7e18},
+		{text: "3.21Qd", value: 3.21e48},
+		{text: "1tT", value: 1e93},
+		{text: "", fails: true},
+		{text: "abc", fails: true},
+		{text: "12X", fails: true},
+		{text: "1.2.3", fails: true},
+	}
+	for _, test := range tests {
+		value, err := parsenumber(test.text)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parsenumber(%q) = %v, want an error", test.text, value)
+			}
+			continue
+		}
+		if err != nil || !nearly(value, test.value) {
+			t.Errorf("parsenumber(%q) = %v, %v, want %v", test.text, value, err, test.value)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		text  string
+		value time.Duration
+		fails bool
+	}{
+		{text: "45s", value: 45 * time.Second},
+		{text: "1h 23m", value: time.Hour + 23*time.Minute},
+		{text: "2d4h", value: 52 * time.Hour},
+		{text: "12:05", value: 12*time.Minute + 5*time.Second},
+		{text: "1:02:03", value: time.Hour + 2*time.Minute + 3*time.Second},
+		{text: "", fails: true},
+		{text: "12", fails: true},
+		{text: "h", fails: true},
+		{text: "1x", fails: true},
+		{text: "1:a", fails: true},
+	}
+	for _, test := range tests {
+		value, err := parseduration(test.text)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseduration(%q) = %v, want an error", test.text, value)
+			}
+			continue
+		}
+		if err != nil || value != test.value {
+			t.Errorf("parseduration(%q) = %v, %v, want %v", test.text, value, err, test.value)
+		}
+	}
+}
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		text  string
+		value float64
+		fails bool
+	}{
+		{text: "1.2q/5q", value: 0.24},
+		{text: "500T/1q", value: 0.5},
+		{text: "5q/5q", value: 1},
+		{text: "6q/5q", value: 1.2},
+		{text: "0/10M", value: 0},
+		{text: "1.2q", fails: true},
+		{text: "1.2q/0", fails: true},
+		{text: "1.2q/5X", fails: true},
+		{text: "/5q", fails: true},
+	}
+	for _, test := range tests {
+		value, err := parseprogress(test.text)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseprogress(%q) = %v, want an error", test.text, value)
+			}
+			continue
+		}
+		if err != nil || math.Abs(value-test.value) > 1e-9 {
+			t.Errorf("parseprogress(%q) = %v, %v, want %v", test.text, value, err, test.value)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// prestigeconfig decides when to prestige. Prestige can't be undone, so
+// every step has to see the template it expects twice in a row and the
+// screen classified as expected before it clicks, and with confirm only (the
+// default) or a gain that could only be estimated it just raises an alert
+type prestigeconfig struct {
+	Enabled     bool       `json:"enabled"`
+	ConfirmOnly bool       `json:"confirm_only"` // alert instead of prestiging
+	Ratio       float64    `json:"ratio"`        // soul eggs gained compared to soul eggs owned
+	MinFarmTime duration   `json:"min_farm_time"`
+	MaxFarmTime duration   `json:"max_farm_time"` // prestige after this long whatever the gain, 0 to never
+	Exponent    float64    `json:"exponent"`      // soul eggs gained is about factor * farm value ^ exponent
+	Menu        [2]float64 `json:"menu"`          // where the menu button is on the farm
+	Gain        [4]float64 `json:"gain"`          // where the prestige dialog shows the soul eggs gained
+	State       string     `json:"state"`
+
+	// Templates for each step
+	PrestigeButton string `json:"prestige_button"` // in the menu
+	ConfirmButton  string `json:"confirm_button"`  // in the prestige dialog
+
+	state prestigestate
+}
+
+// prestigestate is kept across restarts
+type prestigestate struct {
+	FarmStart time.Time `json:"farm_start"`
+	Factor    float64   `json:"factor"` // fitted from the last time the gain and farm value were both read, 0 until then
+}
+
+var defaultprestige = prestigeconfig{
+	Enabled:        true,
+	ConfirmOnly:    true,
+	Ratio:          0.5,
+	MinFarmTime:    duration(time.Hour),
+	Exponent:       0.21,
+	Menu:           [2]float64{0.91, 0.935},
+	Gain:           [4]float64{0.30, 0.52, 0.70, 0.56},
+	State:          "prestige_state.json",
+	PrestigeButton: "prestige_button",
+	ConfirmButton:  "prestige_confirm_button",
+}
+
+func loadprestige(filename string) (*prestigeconfig, error) {
+	pc := defaultprestige
+	if _, err := loadjson(filename, &pc); err != nil {
+		return nil, err
+	}
+
+	pc.state = prestigestate{FarmStart: time.Now()}
+	found, err := loadjson(pc.State, &pc.state)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		pc.save()
+	}
+	return &pc, nil
+}
+
+func (pc *prestigeconfig) save() {
+	data, err := json.MarshalIndent(pc.state, "", "  ")
+	if err == nil {
+		err = os.WriteFile(pc.State, data, 0644)
+	}
+	if err != nil {
+		fmt.Printf("Could not save prestige state: %v\n", err)
+	}
+}
+
+// estimate is the soul eggs prestiging would give for a farm value, if
+// the factor has been fitted yet
+func (pc *prestigeconfig) estimate(farmvalue float64) (float64, bool) {
+	if pc.state.Factor <= 0 || farmvalue <= 0 {
+		return 0, false
+	}
+	return pc.state.Factor * math.Pow(farmvalue, pc.Exponent), true
+}
+
+// plausible is whether a gain that was read is near the estimate, a misread
+// suffix is off by a factor 1000
+func plausible(gain, estimate float64) bool {
+	return gain > estimate/5 && gain < estimate*5
+}
+
+// decide is whether to prestige with this gain, and why
+func (pc *prestigeconfig) decide(gain, soul float64, now time.Time) (bool, string) {
+	onfarm := now.Sub(pc.state.FarmStart)
+	if onfarm < time.Duration(pc.MinFarmTime) {
+		return false, fmt.Sprintf("only on this farm for %v", onfarm.Round(time.Minute))
+	}
+	if pc.MaxFarmTime > 0 && onfarm >= time.Duration(pc.MaxFarmTime) && gain > 0 {
+		return true, fmt.Sprintf("on this farm for %v", onfarm.Round(time.Minute))
+	}
+	if soul <= 0 {
+		return gain > 0, fmt.Sprintf("%.4g soul eggs to gain and none yet", gain)
+	}
+	ratio := gain / soul
+	return ratio >= pc.Ratio, fmt.Sprintf("%.4g soul eggs to gain is %.0f%% of %.4g", gain, ratio*100, soul)
+}
+
+// expect checks a template is on two fresh screens in a row, and returns the last one
+func (pc *prestigeconfig) expect(s *session, name string) (gocv.Mat, bool) {
+	var mat gocv.Mat
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			mat.Close()
+			time.Sleep(time.Millisecond * 500)
+		}
+		mat = s.capture()
+		if _, found := s.find(mat, name); !found {
+			mat.Close()
+			return gocv.Mat{}, false
+		}
+	}
+	return mat, true
+}
+
+// prestige opens the prestige dialog, reads the soul eggs to gain, and
+// prestiges if the rule says so, or alerts in confirm only mode
+func (pc *prestigeconfig) prestige(s *session, alerts *alerter, readings map[string]reading) {
+	if !pc.Enabled || !s.reader.enabled() {
+		return
+	}
+	soul, known := readings["soul_eggs"]
+	if !known || soul.confidence == 0 {
+		fmt.Println("Soul eggs not read yet, not thinking about prestige")
+		return
+	}
+	if time.Since(pc.state.FarmStart) < time.Duration(pc.MinFarmTime) {
+		return
+	}
+
+	if current := s.screen(); current != screenFarmMain {
+		fmt.Printf("Not on the farm but %v, not prestiging\n", current)
+		return
+	}
+	mat := s.capture()
+	s.click(at(mat, pc.Menu), 1)
+	mat.Close()
+	time.Sleep(time.Second * 2)
+	// Back on a new farm there's nothing to leave
+	prestiged := false
+	defer func() {
+		if !prestiged {
+			s.leave()
+		}
+	}()
+
+	mat, found := pc.expect(s, pc.PrestigeButton)
+	if !found {
+		fmt.Printf("No %v in the menu, not prestiging\n", pc.PrestigeButton)
+		return
+	}
+	region, _ := namedregion("farm_value")
+	farmvalue := s.reader.readregion(mat, region)
+	button, _ := s.find(mat, pc.PrestigeButton)
+	mat.Close()
+	s.click(center(button), 1)
+	time.Sleep(time.Second * 2)
+
+	mat, found = pc.expect(s, pc.ConfirmButton)
+	if !found {
+		fmt.Println("The prestige dialog didn't show up, not prestiging")
+		return
+	}
+	gain := s.reader.readregion(mat, ocrregion{name: "prestige_gain", kind: readingNumber, area: pc.Gain})
+	confirm, _ := s.find(mat, pc.ConfirmButton)
+	mat.Close()
+
+	// An estimate is only good enough to tell the player, a prestige needs
+	// the gain read off the dialog, and near the estimate if there is one
+	estimate, estimated := pc.estimate(farmvalue.value)
+	if farmvalue.confidence == 0 {
+		estimated = false
+	}
+	soulgain, sure := gain.value, gain.confidence > 0
+	switch {
+	case !sure && !estimated:
+		fmt.Println("Can't read the soul eggs to gain, and there's nothing to estimate them from, not prestiging")
+		return
+	case !sure:
+		soulgain = estimate
+		fmt.Printf("Estimating %.4g soul eggs to gain from a farm value of %.4g\n", soulgain, farmvalue.value)
+	case estimated && !plausible(soulgain, estimate):
+		fmt.Printf("Read %.4g soul eggs to gain, but expected about %.4g, not trusting it\n", soulgain, estimate)
+		soulgain, sure = estimate, false
+	case farmvalue.confidence > 0 && farmvalue.value > 0:
+		pc.state.Factor = soulgain / math.Pow(farmvalue.value, pc.Exponent)
+		pc.save()
+	}
+
+	do, why := pc.decide(soulgain, soul.value, time.Now())
+	if !do {
+		fmt.Printf("Not prestiging, %v\n", why)
+		return
+	}
+	if pc.ConfirmOnly || !sure {
+		alerts.alert("prestige", fmt.Sprintf("Time to prestige, %v", why))
+		return
+	}
+	if current := s.screen(); current != screenPrestigeDialog {
+		fmt.Printf("Expected the prestige dialog but the screen is %v, not prestiging\n", current)
+		return
+	}
+
+	fmt.Printf("Prestiging, %v\n", why)
+	s.click(center(confirm), 1)
+	time.Sleep(time.Second * 5)
+
+	// Make sure it went through before starting the clock on the new farm
+	mat = s.capture()
+	_, stillthere := s.find(mat, pc.ConfirmButton)
+	_, farm := s.find(mat, "chickenbutton")
+	mat.Close()
+	prestiged = !stillthere && farm && s.screen() == screenFarmMain
+	if !prestiged {
+		alerts.alert("prestige_failed", "Prestige was confirmed but the new farm didn't show up, have a look")
+		return
+	}
+	pc.state.FarmStart = time.Now()
+	pc.save()
+	fmt.Println("Prestiged, starting over")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPrestigeDecide(t *testing.T) {
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		maxfarmtime time.Duration
+		onfarm      time.Duration
+		gain, soul  float64
+		want        bool
+	}{
+		{name: "too soon after the last prestige", onfarm: 59 * time.Minute, gain: 1e12, soul: 1e9},
+		{name: "right after the minimum farm time", onfarm: time.Hour, gain: 5e8, soul: 1e9, want: true},
+		{name: "just under the ratio", onfarm: 2 * time.Hour, gain: 4.99e8, soul: 1e9},
+		{name: "well over the ratio", onfarm: 2 * time.Hour, gain: 3e9, soul: 1e9, want: true},
+		{name: "first prestige", onfarm: 2 * time.Hour, gain: 10, want: true},
+		{name: "nothing to gain yet", onfarm: 2 * time.Hour},
+		{name: "no max farm time", onfarm: 48 * time.Hour, gain: 1e8, soul: 1e9},
+		{name: "past the max farm time", maxfarmtime: 24 * time.Hour, onfarm: 24 * time.Hour, gain: 1e8, soul: 1e9, want: true},
+		{name: "past the max farm time, nothing to gain", maxfarmtime: 24 * time.Hour, onfarm: 48 * time.Hour, soul: 1e9},
+		{name: "before the max farm time", maxfarmtime: 24 * time.Hour, onfarm: 23 * time.Hour, gain: 1e8, soul: 1e9},
+	}
+	for _, test := range tests {
+		pc := defaultprestige
+		pc.MaxFarmTime = duration(test.maxfarmtime)
+		pc.state.FarmStart = start
+		if got, why := pc.decide(test.gain, test.soul, start.Add(test.onfarm)); got != test.want {
+			t.Errorf("%v: decided %v (%v), want %v", test.name, got, why, test.want)
+		}
+	}
+}
+
+func TestPrestigeEstimate(t *testing.T) {
+	pc := defaultprestige
+	if _, estimated := pc.estimate(1e15); estimated {
+		t.Error("estimated without a fitted factor")
+	}
+	// Fitted from a gain of 1e9 at a farm value of 1e15
+	pc.state.Factor = 1e9 / math.Pow(1e15, pc.Exponent)
+	if _, estimated := pc.estimate(0); estimated {
+		t.Error("estimated from an unread farm value")
+	}
+	estimate, estimated := pc.estimate(1e15)
+	if !estimated || math.Abs(estimate-1e9) > 1 {
+		t.Errorf("estimate(1e15) = %v, %v, want 1e9", estimate, estimated)
+	}
+	// A farm 1000 times the value gains 1000^exponent as much
+	if estimate, _ := pc.estimate(1e18); math.Abs(estimate/1e9-math.Pow(1000, pc.Exponent)) > 1e-9 {
+		t.Errorf("estimate(1e18) = %v, want %v", estimate, 1e9*math.Pow(1000, pc.Exponent))
+	}
+}
+
+func TestPlausible(t *testing.T) {
+	tests := []struct {
+		gain, estimate float64
+		want           bool
+	}{
+		{gain: 1e9, estimate: 1e9, want: true},
+		{gain: 3e9, estimate: 1e9, want: true},
+		{gain: 2.5e8, estimate: 1e9, want: true},
+		// A suffix misread by one step is a factor 1000 off
+		{gain: 1e12, estimate: 1e9},
+		{gain: 1e6, estimate: 1e9},
+		// Just outside 5 times either way
+		{gain: 5e9, estimate: 1e9},
+		{gain: 2e8, estimate: 1e9},
+		{gain: 0, estimate: 1e9},
+	}
+	for _, test := range tests {
+		if got := plausible(test.gain, test.estimate); got != test.want {
+			t.Errorf("plausible(%v, %v) = %v, want %v", test.gain, test.estimate, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gocv.io/x/gocv"
+)
+
+// researchconfig is what research to buy. Areas are fractions of the screen
+// from the middle of a row's buy button, measured on the 1080x1920 layout
+type researchconfig struct {
+	Enabled    bool       `json:"enabled"`
+	Strategy   string     `json:"strategy"` // priority or cheapest
+	Priority   []string   `json:"priority"` // research names, or parts of them, most wanted first
+	Epic       bool       `json:"epic"`     // allow spending golden eggs on epic research
+	MaxScrolls int        `json:"max_scrolls"`
+	MaxBuys    int        `json:"max_buys"` // per visit to the research menu
+	Button     [2]float64 `json:"button"`   // where the research button is on the farm
+	Name       [4]float64 `json:"name"`
+	Level      [4]float64 `json:"level"`
+	Cost       [4]float64 `json:"cost"`
+}
+
+var defaultresearch = researchconfig{
+	Enabled:    true,
+	Strategy:   "priority",
+	MaxScrolls: 12,
+	MaxBuys:    10,
+	Button:     [2]float64{0.09, 0.935},
+	Name:       [4]float64{-0.75, -0.035, -0.12, -0.005},
+	Level:      [4]float64{-0.75, -0.005, -0.50, 0.02},
+	Cost:       [4]float64{-0.09, -0.01, 0.09, 0.025},
+}
+
+func loadresearch(filename string) (*researchconfig, error) {
+	rc := defaultresearch
+	if _, err := loadjson(filename, &rc); err != nil {
+		return nil, err
+	}
+	if rc.Strategy != "priority" && rc.Strategy != "cheapest" {
+		return nil, fmt.Errorf("unknown research strategy %q", rc.Strategy)
+	}
+	if rc.Enabled && rc.Strategy == "priority" && len(rc.Priority) == 0 {
+		fmt.Println("No research priority list, not buying research")
+		rc.Enabled = false
+	}
+	return &rc, nil
+}
+
+// researchrow is one research on screen
+type researchrow struct {
+	button image.Point
+	name   string
+	level  string
+	cost   float64
+	epic   bool
+}
+
+// simplename is a name reduced to lower case letters, so OCR spacing doesn't matter
+func simplename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// rows reads the research on screen, top to bottom
+func (rc *researchconfig) rows(s *session, mat gocv.Mat) []researchrow {
+	kinds := []string{"green_research_button"}
+	// Epic research needs its own button template, which isn't shipped
+	if _, found := s.templates["epic_research_button"]; rc.Epic && found {
+		kinds = append(kinds, "epic_research_button")
+	}
+
+	var rows []researchrow
+	for _, kind := range kinds {
+		for _, r := range s.findall(mat, kind, 8) {
+			row := researchrow{button: center(r), epic: kind == "epic_research_button"}
+			row.name, _ = s.reader.read(mat, relative(mat, row.button, rc.Name))
+			row.level, _ = s.reader.read(mat, relative(mat, row.button, rc.Level))
+			text, _ := s.reader.read(mat, relative(mat, row.button, rc.Cost))
+			cost, err := parsenumber(text)
+			if err != nil {
+				// Maxed or unreadable, either way not something to buy
+				continue
+			}
+			row.cost = cost
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].button.Y < rows[j].button.Y
+	})
+	return rows
+}
+
+// pick chooses what to buy from the rows on screen, or -1
+func (rc *researchconfig) pick(rows []researchrow) int {
+	if rc.Strategy == "cheapest" {
+		best := -1
+		for i, row := range rows {
+			if best == -1 || row.cost < rows[best].cost {
+				best = i
+			}
+		}
+		return best
+	}
+	for _, wanted := range rc.Priority {
+		wanted = simplename(wanted)
+		for i, row := range rows {
+			if wanted != "" && strings.Contains(simplename(row.name), wanted) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// buy clicks a research and checks it went through, by the level or the cost changing
+func (rc *researchconfig) buy(s *session, row researchrow) bool {
+	s.click(row.button, 1)
+	time.Sleep(time.Millisecond * 700)
+
+	mat := s.capture()
+	defer mat.Close()
+	level, _ := s.reader.read(mat, relative(mat, row.button, rc.Level))
+	text, _ := s.reader.read(mat, relative(mat, row.button, rc.Cost))
+	cost, err := parsenumber(text)
+	return (level != "" && level != row.level) || (err == nil && cost != row.cost)
+}
+
+// research opens the research menu from the farm and buys what the config
+// says to, scrolling down through the tiers, then goes back to the farm.
+// It returns how many were bought
+func (rc *researchconfig) research(s *session) int {
+	if !rc.Enabled {
+		return 0
+	}
+	if !s.reader.enabled() {
+		fmt.Println("Research needs OCR glyphs to read names and costs, skipping it")
+		return 0
+	}
+
+	mat := s.capture()
+	s.click(at(mat, rc.Button), 1)
+	mat.Close()
+	time.Sleep(time.Second * 2)
+	if current := s.screen(); current != screenResearchMenu {
+		fmt.Printf("Expected the research menu but the screen is %v\n", current)
+		s.backtofarm()
+		return 0
+	}
+	defer s.backtofarm()
+
+	bought := 0
+	var lastnames string
+	for scrolls := 0; scrolls <= rc.MaxScrolls && bought < rc.MaxBuys; {
+		mat := s.capture()
+		rows := rc.rows(s, mat)
+		size := image.Pt(mat.Cols(), mat.Rows())
+		mat.Close()
+
+		var names []string
+		for _, row := range rows {
+			names = append(names, row.name)
+		}
+		if len(rows) == 0 && scrolls == 0 {
+			fmt.Println("No research found on the research menu")
+			return bought
+		}
+
+		if i := rc.pick(rows); i != -1 {
+			row := rows[i]
+			fmt.Printf("Buying research %v (%v) for %.4g\n", row.name, row.level, row.cost)
+			if !rc.buy(s, row) {
+				fmt.Printf("Buying %v didn't go through, stopping research\n", row.name)
+				return bought
+			}
+			bought++
+			// The same one may well be the next pick, so look again before scrolling
+			continue
+		}
+
+		// Nothing more here, on to the next tier
+		joined := strings.Join(names, "|")
+		if joined == lastnames {
+			break
+		}
+		lastnames = joined
+		from := image.Pt(size.X/2, size.Y*3/4)
+		to := image.Pt(size.X/2, size.Y*35/100)
+		s.drag(from, to)
+		time.Sleep(time.Second)
+		scrolls++
+	}
+	fmt.Printf("Bought %v research\n", bought)
+	return bought
+}
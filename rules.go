@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// duration is a time.Duration written as "15m" in JSON
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// rule is one thing the bot may do. The rule with the highest priority whose
+// conditions all hold, and that hasn't fired within its cooldown, is the one
+// that acts
+type rule struct {
+	Name       string   `json:"name"`
+	Priority   int      `json:"priority"`
+	Conditions []string `json:"when"`
+	Action     string   `json:"action"`
+	Target     string   `json:"target,omitempty"` // template or group the action works on
+	Repeat     int      `json:"repeat,omitempty"` // clicks
+	Cooldown   duration `json:"cooldown,omitempty"`
+	Settle     duration `json:"settle,omitempty"` // wait after acting, for the game to catch up
+
+	conditions []condition
+}
+
+// condition is one of
+//
+//	screen is <screen name|dialog>
+//	seen <template or group>
+//	steady <template or group>   seen at the same place the last time too
+//	<template or group>.x > 0.7  position as a fraction of the screen, also .y and <
+//	flag <name>
+//	since <timer> > 60s          also <
+//
+// optionally preceded by not
+type condition struct {
+	text   string
+	negate bool
+	kind   string
+	name   string
+	less   bool
+	value  float64
+	period time.Duration
+}
+
+func parsecondition(text string) (condition, error) {
+	c := condition{text: text}
+	fields := strings.Fields(text)
+	if len(fields) > 0 && fields[0] == "not" {
+		c.negate = true
+		fields = fields[1:]
+	}
+	bad := fmt.Errorf("can't understand condition %q", text)
+
+	comparison := func(op string) error {
+		switch op {
+		case "<":
+			c.less = true
+		case ">":
+		default:
+			return bad
+		}
+		return nil
+	}
+
+	switch {
+	case len(fields) == 3 && fields[0] == "screen" && fields[1] == "is":
+		c.kind, c.name = "screen", fields[2]
+		if _, found := parsegamescreen(c.name); !found && c.name != "dialog" {
+			return c, fmt.Errorf("unknown screen %q in condition %q", c.name, text)
+		}
+	case len(fields) == 2 && (fields[0] == "seen" || fields[0] == "steady" || fields[0] == "flag"):
+		c.kind, c.name = fields[0], fields[1]
+	case len(fields) == 4 && fields[0] == "since":
+		c.kind, c.name = "since", fields[1]
+		if err := comparison(fields[2]); err != nil {
+			return c, err
+		}
+		period, err := time.ParseDuration(fields[3])
+		if err != nil {
+			return c, fmt.Errorf("bad duration in condition %q: %v", text, err)
+		}
+		c.period = period
+	case len(fields) == 3 && (strings.HasSuffix(fields[0], ".x") || strings.HasSuffix(fields[0], ".y")):
+		c.kind, c.name = fields[0][len(fields[0])-1:], fields[0][:len(fields[0])-2]
+		if err := comparison(fields[1]); err != nil {
+			return c, err
+		}
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return c, fmt.Errorf("bad number in condition %q: %v", text, err)
+		}
+		c.value = value
+	default:
+		return c, bad
+	}
+	return c, nil
+}
+
+// rulecontext is what conditions are checked against
+type rulecontext struct {
+	now      time.Time
+	screen   gamescreen
+	size     image.Point            // of the screen the detections are on
+	seen     map[string]image.Point // templates found, and where
+	scores   map[string]float32     // how well the found templates matched, score over threshold, lower is better
+	flags    map[string]bool
+	timers   map[string]time.Time
+	readings map[string]reading
+}
+
+// holds checks the condition, and describes how things really are if it doesn't
+func (re *ruleengine) holds(c condition, ctx *rulecontext) (bool, string) {
+	var ok bool
+	var actual string
+	switch c.kind {
+	case "screen":
+		ok = ctx.screen.String() == c.name || (c.name == "dialog" && ctx.screen.isdialog())
+		actual = "screen is " + ctx.screen.String()
+	case "seen":
+		_, ok = re.lookup(ctx.seen, c.name)
+		actual = c.name + " not seen"
+		if ok {
+			actual = c.name + " seen"
+		}
+	case "steady":
+		p, seen := re.lookup(ctx.seen, c.name)
+		last, seenbefore := re.lookup(re.previous, c.name)
+		ok = seen && seenbefore && p == last
+		actual = c.name + " is moving or not there"
+		if ok {
+			actual = c.name + " is steady"
+		}
+	case "x", "y":
+		p, seen := re.lookup(ctx.seen, c.name)
+		if !seen {
+			return c.negate, c.name + " not seen"
+		}
+		f := float64(p.X) / float64(ctx.size.X)
+		if c.kind == "y" {
+			f = float64(p.Y) / float64(ctx.size.Y)
+		}
+		ok = (c.less && f < c.value) || (!c.less && f > c.value)
+		actual = fmt.Sprintf("%v.%v is %.2f", c.name, c.kind, f)
+	case "flag":
+		ok = ctx.flags[c.name]
+		actual = fmt.Sprintf("%v is %v", c.name, ok)
+	case "since":
+		since := ctx.now.Sub(ctx.timers[c.name])
+		ok = (c.less && since < c.period) || (!c.less && since > c.period)
+		actual = fmt.Sprintf("%v was %v ago", c.name, since.Round(time.Second))
+	}
+	return ok != c.negate, actual
+}
+
+// ruleaction does what a rule says, target is where its target was seen
+type ruleaction func(r *rule, ctx *rulecontext, target image.Point)
+
+// rulefile is how rules are written, with names for groups of templates that
+// mean the same thing
+type rulefile struct {
+	Groups map[string][]string `json:"groups"`
+	Rules  []*rule             `json:"rules"`
+}
+
+type ruleengine struct {
+	rules  []*rule // highest priority first
+	groups map[string][]string
+
+	lastfired map[string]time.Time
+	skipped   map[string]string      // why each rule didn't fire the last time, logged when it changes
+	previous  map[string]image.Point // detections from the last evaluation
+	acted     string                 // rule that fired the last evaluation, its steady things have to settle again
+	disabled  map[string]string      // rules turned off, and by what
+}
+
+// loadrules reads the rules from filename, or the built in ones if it doesn't exist
+func loadrules(filename string) (*ruleengine, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = assets.ReadFile("assets/rules.json")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rf rulefile
+	if err = json.Unmarshal(data, &rf); err != nil {
+		return nil, fmt.Errorf("parsing %v: %v", filename, err)
+	}
+	return newruleengine(rf)
+}
+
+func newruleengine(rf rulefile) (*ruleengine, error) {
+	re := &ruleengine{
+		rules:     rf.Rules,
+		groups:    rf.Groups,
+		lastfired: make(map[string]time.Time),
+		skipped:   make(map[string]string),
+		disabled:  make(map[string]string),
+	}
+	for _, r := range re.rules {
+		r.conditions = nil
+		for _, text := range r.Conditions {
+			c, err := parsecondition(text)
+			if err != nil {
+				return nil, fmt.Errorf("rule %v: %v", r.Name, err)
+			}
+			r.conditions = append(r.conditions, c)
+		}
+	}
+	sort.SliceStable(re.rules, func(i, j int) bool {
+		return re.rules[i].Priority > re.rules[j].Priority
+	})
+	return re, nil
+}
+
+// check warns about rules using actions or templates that don't exist
+func (re *ruleengine) check(actions map[string]ruleaction, templates map[string]*template) error {
+	known := func(name string) bool {
+		if _, found := templates[name]; found {
+			return true
+		}
+		_, found := re.groups[name]
+		return found
+	}
+	for _, r := range re.rules {
+		if _, found := actions[r.Action]; !found {
+			return fmt.Errorf("rule %v has unknown action %q", r.Name, r.Action)
+		}
+		if r.Target != "" && !known(r.Target) {
+			fmt.Printf("Rule %v clicks %v, which there is no template for\n", r.Name, r.Target)
+		}
+		for _, c := range r.conditions {
+			if (c.kind == "seen" || c.kind == "steady" || c.kind == "x" || c.kind == "y") && !known(c.name) {
+				fmt.Printf("Rule %v looks for %v, which there is no template for\n", r.Name, c.name)
+			}
+		}
+	}
+	return nil
+}
+
+// lookup finds a template, or any template in a group
+func (re *ruleengine) lookup(seen map[string]image.Point, name string) (image.Point, bool) {
+	if p, found := seen[name]; found {
+		return p, true
+	}
+	for _, member := range re.groups[name] {
+		if p, found := seen[member]; found {
+			return p, true
+		}
+	}
+	return image.Point{}, false
+}
+
+// evaluate picks the rule to act on and where its target is, or nil. Why
+// a rule is skipped is logged when it's for another reason than last time
+func (re *ruleengine) evaluate(ctx *rulecontext) (*rule, image.Point) {
+	// Whatever fires, the next evaluation compares against these detections
+	defer func() {
+		re.previous = ctx.seen
+	}()
+	acted := re.acted
+	re.acted = ""
+
+	for _, r := range re.rules {
+		// The reason is what's logged, the key what's compared, as the
+		// actual values change all the time
+		var reason, key string
+		if by, found := re.disabled[r.Name]; found {
+			key = "disabled"
+			reason = "disabled by " + by
+		} else if last := re.lastfired[r.Name]; ctx.now.Sub(last) < time.Duration(r.Cooldown) {
+			key = "cooldown"
+			reason = fmt.Sprintf("cooling down until %v", last.Add(time.Duration(r.Cooldown)).Format("15:04:05"))
+		}
+		var why []string
+		for _, c := range r.conditions {
+			if key != "" {
+				break
+			}
+			ok, actual := re.holds(c, ctx)
+			if c.kind == "steady" && !c.negate && acted == r.Name {
+				ok, actual = false, c.name+" is settling after acting"
+			}
+			if !ok {
+				key = c.text
+				reason = fmt.Sprintf("%v (%v)", c.text, actual)
+			} else {
+				why = append(why, actual)
+			}
+		}
+		var target image.Point
+		if key == "" && r.Target != "" {
+			var found bool
+			if target, found = re.lookup(ctx.seen, r.Target); !found {
+				key = "target"
+				reason = r.Target + " not seen"
+			}
+		}
+
+		if key != "" {
+			if re.skipped[r.Name] != key {
+				fmt.Printf("Rule %v skipped: %v\n", r.Name, reason)
+				re.skipped[r.Name] = key
+			}
+			continue
+		}
+
+		fmt.Printf("Rule %v fired: %v\n", r.Name, strings.Join(why, ", "))
+		re.lastfired[r.Name] = ctx.now
+		delete(re.skipped, r.Name)
+		// What this rule waited for has to settle again after acting
+		re.acted = r.Name
+		return r, target
+	}
+	return nil, image.Point{}
+}
+
+func (re *ruleengine) disable(name, by string) error {
+	for _, r := range re.rules {
+		if r.Name == name {
+			re.disabled[name] = by
+			return nil
+		}
+	}
+	return fmt.Errorf("no rule named %q", name)
+}
+
+func (re *ruleengine) enable(name string) error {
+	for _, r := range re.rules {
+		if r.Name == name {
+			delete(re.disabled, name)
+			return nil
+		}
+	}
+	return fmt.Errorf("no rule named %q", name)
+}
+
+func (r *rule) clicks() int {
+	if r.Repeat > 0 {
+		return r.Repeat
+	}
+	return 1
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  condition
+		fails bool
+	}{
+		{text: "screen is FarmMain", want: condition{kind: "screen", name: "FarmMain"}},
+		{text: "screen is dialog", want: condition{kind: "screen", name: "dialog"}},
+		{text: "not screen is AdPlaying", want: condition{kind: "screen", name: "AdPlaying", negate: true}},
+		{text: "seen ok", want: condition{kind: "seen", name: "ok"}},
+		{text: "not seen boost_active_soul_mirror", want: condition{kind: "seen", name: "boost_active_soul_mirror", negate: true}},
+		{text: "steady package", want: condition{kind: "steady", name: "package"}},
+		{text: "flag watching_ad", want: condition{kind: "flag", name: "watching_ad"}},
+		{text: "not flag habs_full", want: condition{kind: "flag", name: "habs_full", negate: true}},
+		{text: "since lastok > 60s", want: condition{kind: "since", name: "lastok", period: time.Minute}},
+		{text: "since missions_due < 0s", want: condition{kind: "since", name: "missions_due", less: true}},
+		{text: "watch_ad.x > 0.7", want: condition{kind: "x", name: "watch_ad", value: 0.7}},
+		{text: "package.y < 0.25", want: condition{kind: "y", name: "package", less: true, value: 0.25}},
+		{text: "", fails: true},
+		{text: "not", fails: true},
+		{text: "screen is Moon", fails: true},
+		{text: "screen FarmMain", fails: true},
+		{text: "seen", fails: true},
+		{text: "flag a b", fails: true},
+		{text: "since lastok = 60s", fails: true},
+		{text: "since lastok > soon", fails: true},
+		{text: "watch_ad.x >= 0.7", fails: true},
+		{text: "watch_ad.x > far", fails: true},
+		{text: "watch_ad.z > 0.7", fails: true},
+	}
+	for _, test := range tests {
+		c, err := parsecondition(test.text)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parsecondition(%q) = %+v, want an error", test.text, c)
+			}
+			continue
+		}
+		test.want.text = test.text
+		if err != nil || c != test.want {
+			t.Errorf("parsecondition(%q) = %+v, %v, want %+v", test.text, c, err, test.want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	rf := rulefile{
+		Groups: map[string][]string{"ok": {"blue_ok_button", "grey_ok_button"}},
+		Rules: []*rule{
+			// Out of order, the engine sorts them
+			{Name: "acknowledge", Priority: 490, Conditions: []string{"screen is GenericDialog"}, Action: "click", Target: "ok"},
+			{Name: "ad finished", Priority: 1000, Conditions: []string{"flag watching_ad", "not screen is AdPlaying"}, Action: "ad_done"},
+			{Name: "watching ad", Priority: 980, Conditions: []string{"flag watching_ad"}, Action: "wait"},
+			{Name: "launch", Priority: 800, Conditions: []string{"screen is Launcher"}, Action: "launch"},
+			{Name: "restart", Priority: 900, Conditions: []string{"since lastok > 60s"}, Action: "restart_app", Cooldown: duration(time.Minute)},
+			{Name: "check ad offer", Priority: 380, Conditions: []string{"screen is FarmMain", "watch_ad.x > 0.7"}, Action: "click", Target: "watch_ad"},
+			{Name: "move to silo", Priority: 100, Conditions: []string{"screen is FarmMain", "not seen silo"}, Action: "move_to_silo"},
+		},
+	}
+	size := image.Pt(1000, 2000)
+	tests := []struct {
+		name       string
+		screen     gamescreen
+		seen       map[string]image.Point
+		flags      map[string]bool
+		lastok     time.Duration // ago
+		lastfired  map[string]time.Duration
+		want       string
+		wanttarget image.Point
+	}{
+		{name: "nothing holds", screen: screenUnknown},
+		{name: "only rule that holds", screen: screenLauncher, want: "launch"},
+		{name: "highest priority wins", screen: screenGenericDialog, flags: map[string]bool{"watching_ad": true}, want: "ad finished"},
+		{name: "negated screen", screen: screenAdPlaying, flags: map[string]bool{"watching_ad": true}, want: "watching ad"},
+		{name: "since", screen: screenLauncher, lastok: 2 * time.Minute, want: "restart"},
+		{name: "cooling down", screen: screenLauncher, lastok: 2 * time.Minute, lastfired: map[string]time.Duration{"restart": 30 * time.Second}, want: "launch"},
+		{name: "cooled down", screen: screenLauncher, lastok: 2 * time.Minute, lastfired: map[string]time.Duration{"restart": 90 * time.Second}, want: "restart"},
+		{name: "target through a group", screen: screenGenericDialog, seen: map[string]image.Point{"grey_ok_button": {500, 1200}}, want: "acknowledge", wanttarget: image.Pt(500, 1200)},
+		{name: "target missing", screen: screenGenericDialog},
+		{name: "position", screen: screenFarmMain, seen: map[string]image.Point{"watch_ad": {900, 800}, "silo": {100, 1500}}, want: "check ad offer", wanttarget: image.Pt(900, 800)},
+		{name: "position too far left", screen: screenFarmMain, seen: map[string]image.Point{"watch_ad": {500, 800}, "silo": {100, 1500}}},
+		{name: "not seen", screen: screenFarmMain, seen: map[string]image.Point{"watch_ad": {500, 800}}, want: "move to silo"},
+	}
+	for _, test := range tests {
+		re, err := newruleengine(rf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, ago := range test.lastfired {
+			re.lastfired[name] = now.Add(-ago)
+		}
+		ctx := &rulecontext{
+			now:    now,
+			screen: test.screen,
+			size:   size,
+			seen:   test.seen,
+			flags:  test.flags,
+			timers: map[string]time.Time{"lastok": now.Add(-test.lastok)},
+		}
+		r, target := re.evaluate(ctx)
+		var got string
+		if r != nil {
+			got = r.Name
+		}
+		if got != test.want || target != test.wanttarget {
+			t.Errorf("%v: fired %q at %v, want %q at %v", test.name, got, target, test.want, test.wanttarget)
+		}
+	}
+}
+
+// The shipped rules have to parse
+func TestBuiltinRules(t *testing.T) {
+	if _, err := loadrules("no such file"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// plannedshot is a drone we intend to shoot, and why
+type plannedshot struct {
+	track      *track
+	aim        image.Point
+	timetoexit time.Duration
+	confidence float64
+	score      float64
+}
+
+// scheduler decides which drones to shoot at and in what order. Drones about
+// to leave the screen come first, elite drones count more, and so do drones
+// the filter is sure about. Shots in one sequence are fired without waiting
+// for a new frame, so they have to be far enough apart not to disturb each
+// other; drones close to an earlier shot wait for the next frame. The order
+// is kept until a shot's result comes in, or a drone shows up it doesn't know
+type scheduler struct {
+	maxshots    int     // shots per frame, later ones are aimed with older information
+	spacing     int     // pixels between shots in one sequence
+	elitereward float64 // how much more an elite drone is worth
+
+	planned    []plannedshot
+	considered map[*track]bool // candidates when the order was planned
+	stale      bool
+}
+
+func newscheduler(screenwidth int) *scheduler {
+	return &scheduler{
+		maxshots:    3,
+		spacing:     screenwidth / 4,
+		elitereward: 3,
+	}
+}
+
+// eligible is false for drones we shouldn't shoot at (yet)
+func (s *scheduler) eligible(tr *track, screenwidth int) bool {
+	if !tr.updated || (tr.state != trackConfirmed && !(tr.state == trackTentative && tr.preaimed)) {
+		return false
+	}
+	drone := tr.positions[len(tr.positions)-1]
+	if !tr.preaimed && distance(drone, tr.positions[0]) < screenwidth/15 {
+		// Non-moving false positive. Preaimed tracks were already seen flying like a drone
+		return false
+	}
+	if len(tr.zaps) > 0 {
+		switch tr.zaps[len(tr.zaps)-1].outcome {
+		case shotPending, shotHit:
+			// Wait for the result, or it's already going down
+			return false
+		}
+	}
+	return true
+}
+
+// timetoexit is how long until the track leaves bounds, flying as it is now
+func timetoexit(tr *track, bounds image.Rectangle) time.Duration {
+	vx, vy := tr.filter.velocity()
+	p := tr.filter.position()
+	exit := math.Inf(1)
+	if vx > 0 {
+		exit = math.Min(exit, float64(bounds.Max.X-p.X)/vx)
+	} else if vx < 0 {
+		exit = math.Min(exit, float64(bounds.Min.X-p.X)/vx)
+	}
+	if vy > 0 {
+		exit = math.Min(exit, float64(bounds.Max.Y-p.Y)/vy)
+	} else if vy < 0 {
+		exit = math.Min(exit, float64(bounds.Min.Y-p.Y)/vy)
+	}
+	if math.IsInf(exit, 1) || exit > 60 {
+		return time.Minute
+	}
+	if exit < 0 {
+		return 0
+	}
+	return time.Duration(exit * float64(time.Second))
+}
+
+// resolved is told when a shot's result came in, so the order is planned again
+func (s *scheduler) resolved() {
+	s.stale = true
+}
+
+// order is the candidates to shoot at, best first. It's planned again when a
+// shot's result came in or there's a candidate it didn't consider, otherwise
+// the last plan is kept, less the drones that are no longer candidates
+func (s *scheduler) order(candidates []*track, bounds image.Rectangle, excluded func(image.Point) bool, lead time.Duration) []plannedshot {
+	for _, tr := range candidates {
+		if !s.considered[tr] {
+			s.stale = true
+		}
+	}
+	if s.stale {
+		s.planned = s.plan(candidates, bounds, excluded, lead, nil)
+		s.considered = make(map[*track]bool, len(candidates))
+		for _, tr := range candidates {
+			s.considered[tr] = true
+		}
+		s.stale = false
+		if len(s.planned) > 1 {
+			fmt.Printf("Shot plan: %v\n", describeplan(s.planned))
+		}
+	}
+
+	current := make(map[*track]bool, len(candidates))
+	for _, tr := range candidates {
+		current[tr] = true
+	}
+	var order []plannedshot
+	for _, ps := range s.planned {
+		if current[ps.track] {
+			order = append(order, ps)
+		}
+	}
+	return order
+}
+
+// clear is whether a shot at aim stays in bounds, out of the excluded areas
+// and away from the shots already fired from this frame
+func (s *scheduler) clear(aim image.Point, bounds image.Rectangle, excluded func(image.Point) bool, fired []image.Point) bool {
+	if !aim.In(bounds) || excluded(aim) {
+		return false
+	}
+	for _, f := range fired {
+		if distance(aim, f) < s.spacing {
+			return false
+		}
+	}
+	return true
+}
+
+// plan ranks the candidates for shots landing lead after the frame, leaving
+// out any that will have left bounds or flown into an excluded area, and any
+// too close to where we already fired in this frame
+func (s *scheduler) plan(candidates []*track, bounds image.Rectangle, excluded func(image.Point) bool, lead time.Duration, fired []image.Point) []plannedshot {
+	var shots []plannedshot
+	for _, tr := range candidates {
+		aim := tr.filter.peek(lead.Seconds())
+		if !s.clear(aim, bounds, excluded, fired) {
+			continue
+		}
+
+		tte := timetoexit(tr, bounds) - lead
+		if tte < 0 {
+			tte = 0
+		}
+		uncertainty := math.Sqrt(tr.filter.p[0][0] + tr.filter.p[1][1])
+		confidence := 1 / (1 + uncertainty/10)
+
+		reward := 1.0
+		if tr.elite() {
+			reward = s.elitereward
+		}
+		urgency := 1 / (tte.Seconds() + 0.25)
+
+		shots = append(shots, plannedshot{
+			track:      tr,
+			aim:        aim,
+			timetoexit: tte,
+			confidence: confidence,
+			score:      reward * confidence * urgency,
+		})
+	}
+
+	sort.Slice(shots, func(i, j int) bool {
+		return shots[i].score > shots[j].score
+	})
+	return shots
+}
+
+func (ps plannedshot) String() string {
+	return fmt.Sprintf("#%v (exit %v, confidence %.2f, score %.2f)", ps.track.id, ps.timetoexit.Round(time.Millisecond), ps.confidence, ps.score)
+}
+
+func describeplan(shots []plannedshot) string {
+	var parts []string
+	for _, ps := range shots {
+		parts = append(parts, ps.String())
+	}
+	return strings.Join(parts, ", ")
+}
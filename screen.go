@@ -0,0 +1,198 @@
+package main
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+type gamescreen int
+
+const (
+	screenUnknown gamescreen = iota
+	screenLauncher
+	screenFarmMain
+	screenBoostsDialog
+	screenAdOfferDialog
+	screenAdPlaying
+	screenResearchMenu
+	screenMissionScreen
+	screenGenericDialog
+	screenPrestigeDialog
+)
+
+var gamescreennames = map[gamescreen]string{
+	screenUnknown:        "Unknown",
+	screenLauncher:       "Launcher",
+	screenFarmMain:       "FarmMain",
+	screenBoostsDialog:   "BoostsDialog",
+	screenAdOfferDialog:  "AdOfferDialog",
+	screenAdPlaying:      "AdPlaying",
+	screenResearchMenu:   "ResearchMenu",
+	screenMissionScreen:  "MissionScreen",
+	screenGenericDialog:  "GenericDialog",
+	screenPrestigeDialog: "PrestigeDialog",
+}
+
+func (s gamescreen) String() string {
+	return gamescreennames[s]
+}
+
+func parsegamescreen(name string) (gamescreen, bool) {
+	for s, n := range gamescreennames {
+		if n == name {
+			return s, true
+		}
+	}
+	return screenUnknown, false
+}
+
+func (s gamescreen) isdialog() bool {
+	switch s {
+	case screenBoostsDialog, screenAdOfferDialog, screenGenericDialog:
+		return true
+	}
+	return false
+}
+
+// Which screen a template is evidence for, and how much it counts. Dialog
+// templates weigh more than farm ones, as the farm is often still visible
+// behind the dialog
+var screenevidence = map[string]struct {
+	screen gamescreen
+	weight float32
+}{
+	"launchicon":                      {screenLauncher, 2},
+	"launchicon_hat":                  {screenLauncher, 2},
+	"launchicon_hat_2":                {screenLauncher, 2},
+	"chickenbutton":                   {screenFarmMain, 1},
+	"hatch_green":                     {screenFarmMain, 0.5},
+	"silo":                            {screenFarmMain, 0.5},
+	"boosts_button":                   {screenFarmMain, 0.5},
+	"max_chicken_running_bonus":       {screenFarmMain, 0.5},
+	"video_double_indicator":          {screenFarmMain, 0.5},
+	"package":                         {screenFarmMain, 0.3},
+	"watch_ad":                        {screenFarmMain, 0.3},
+	"boosts_watch_ad":                 {screenBoostsDialog, 2},
+	"ad_offer_watch_button":           {screenAdOfferDialog, 1.5},
+	"ad_offer_no_thanks_button":       {screenAdOfferDialog, 1.5},
+	"ad_offer_boost":                  {screenAdOfferDialog, 1},
+	"ad_offer_eggs":                   {screenAdOfferDialog, 1},
+	"ad_offer_box_of_eggs":            {screenAdOfferDialog, 1},
+	"ad_offer_crate_of_eggs":          {screenAdOfferDialog, 1},
+	"ad_offer_chicken_box":            {screenAdOfferDialog, 1},
+	"ad_offer_large_chicken_box":      {screenAdOfferDialog, 1},
+	"ad_offer_tickets":                {screenAdOfferDialog, 1},
+	"ad_offer_money":                  {screenAdOfferDialog, 1},
+	"ad_offer_a_ton_of_cash":          {screenAdOfferDialog, 1},
+	"green_research_button":           {screenResearchMenu, 2},
+	"collect_mission_button":          {screenMissionScreen, 2},
+	"mission_launch_button":           {screenMissionScreen, 2},
+	"prestige_confirm_button":         {screenPrestigeDialog, 3},
+	"lightblue_ok_button":             {screenGenericDialog, 1.5},
+	"blue_ok_button":                  {screenGenericDialog, 1.5},
+	"pink_ok_button":                  {screenGenericDialog, 1.5},
+	"purple_ok_button":                {screenGenericDialog, 1.5},
+	"grey_ok_button":                  {screenGenericDialog, 1.5},
+	"blue_close_button":               {screenGenericDialog, 1.2},
+	"green_close_button":              {screenGenericDialog, 1.2},
+	"purple_close_button":             {screenGenericDialog, 1.2},
+	"red_close_button":                {screenGenericDialog, 1.2},
+	"collect_button":                  {screenGenericDialog, 1.5},
+	"purple_collect_button":           {screenGenericDialog, 1.5},
+	"collect_and_refill_silos_button": {screenGenericDialog, 1.5},
+	"collect_artifact_reward_button":  {screenGenericDialog, 1.5},
+	"contract_reward_button":          {screenGenericDialog, 1.5},
+}
+
+// screenclassifier keeps the brightness of the undimmed farm, so it can
+// tell when something is drawn on top of it
+type screenclassifier struct {
+	farmbrightness float64
+}
+
+// borderbrightness is the mean brightness of the left and right edges,
+// which dialogs never cover but dim with their backdrop
+func borderbrightness(screen gocv.Mat) float64 {
+	w := screen.Cols() / 20
+	if w == 0 {
+		return 0
+	}
+	left := screen.Region(image.Rect(0, screen.Rows()/4, w, screen.Rows()*3/4))
+	right := screen.Region(image.Rect(screen.Cols()-w, screen.Rows()/4, screen.Cols(), screen.Rows()*3/4))
+	lm, rm := left.Mean(), right.Mean()
+	left.Close()
+	right.Close()
+	return (lm.Val1 + lm.Val2 + lm.Val3 + rm.Val1 + rm.Val2 + rm.Val3) / 6
+}
+
+// classify combines template hits with the dimming of the screen edges and
+// the placement of dialog buttons, and returns the best guess along with a
+// 0-1 confidence
+func (sc *screenclassifier) classify(results []result, screen gocv.Mat, watchingad bool) (gamescreen, float32) {
+	scores := make(map[gamescreen]float32)
+	for _, res := range results {
+		if res.confidence >= res.threshold {
+			continue
+		}
+		ev, found := screenevidence[res.name]
+		if !found {
+			continue
+		}
+		strength := 0.5 + 0.5*(1-res.confidence/res.threshold)
+		// Dialog buttons are centered, something on the far edge is likely not a dialog
+		if ev.screen.isdialog() && (res.location.X < screen.Cols()/6 || res.location.X > screen.Cols()*5/6) {
+			strength /= 2
+		}
+		scores[ev.screen] += ev.weight * strength
+	}
+
+	brightness := borderbrightness(screen)
+	dimmed := sc.farmbrightness > 0 && brightness < sc.farmbrightness*0.7
+	if dimmed {
+		scores[screenFarmMain] /= 2
+		for s := range scores {
+			if s.isdialog() {
+				scores[s] += 0.5
+			}
+		}
+		if len(scores) == 1 && scores[screenFarmMain] > 0 {
+			// Something is covering the farm, but we don't know what
+			scores[screenGenericDialog] += 0.3
+		}
+	}
+
+	// Ads show none of our templates, so an empty screen while an ad is running
+	// is the ad. Anything we know, like the reward dialog after it, means it's over
+	if watchingad && len(scores) == 0 {
+		scores[screenAdPlaying] += 1
+	}
+
+	best := screenUnknown
+	var bestscore, total float32
+	for s, score := range scores {
+		total += score
+		if score > bestscore {
+			best, bestscore = s, score
+		}
+	}
+	if best == screenUnknown {
+		return screenUnknown, 0
+	}
+
+	confidence := bestscore / total
+	if bestscore < 1 {
+		confidence *= bestscore
+	}
+
+	// Learn what the farm looks like when nothing covers it
+	if best == screenFarmMain && !dimmed && confidence > 0.8 {
+		if sc.farmbrightness == 0 {
+			sc.farmbrightness = brightness
+		} else {
+			sc.farmbrightness = sc.farmbrightness*0.9 + brightness*0.1
+		}
+	}
+
+	return best, confidence
+}
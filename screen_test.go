@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+func TestClassifyWatchingAd(t *testing.T) {
+	screen := gocv.NewMatWithSize(1920, 1080, gocv.MatTypeCV8UC3)
+	defer screen.Close()
+	seen := func(name string, x, y int) result {
+		return result{name: name, confidence: 0.01, threshold: 0.1, location: image.Pt(x, y)}
+	}
+	tests := []struct {
+		name    string
+		results []result
+		want    gamescreen
+	}{
+		{name: "ad running", want: screenAdPlaying},
+		{name: "reward dialog after the ad", results: []result{seen("collect_button", 540, 1300)}, want: screenGenericDialog},
+		{name: "ok dialog after the ad", results: []result{seen("blue_ok_button", 540, 1300)}, want: screenGenericDialog},
+		{name: "back on the farm", results: []result{seen("chickenbutton", 540, 1750), seen("silo", 100, 1300)}, want: screenFarmMain},
+		{name: "app left", results: []result{seen("launchicon", 300, 900)}, want: screenLauncher},
+	}
+	for _, test := range tests {
+		var classifier screenclassifier
+		if got, _ := classifier.classify(test.results, screen, true); got != test.want {
+			t.Errorf("%v: classified as %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// scriptapi is what scripts can do to the game, in detection coordinates
+type scriptapi struct {
+	actions map[string]ruleaction
+	engine  *ruleengine
+	click   func(p image.Point, repeat int)
+	drag    func(from, to image.Point)
+	keys    map[string]uintptr
+	sendkey func(key uintptr)
+}
+
+type scripttimer struct {
+	every time.Duration
+	fn    starlark.Callable
+	last  time.Time
+}
+
+type script struct {
+	name    string
+	thread  *starlark.Thread
+	globals starlark.StringDict
+	timers  []*scripttimer
+}
+
+// scripting runs Starlark scripts from a folder. A script can define
+//
+//	on_frame(state)            after each time the screen is analyzed
+//	on_screen(previous, state) when the game changes screen
+//
+// and call every("30s", fn) to have fn(state) called on a timer. The state
+// holds the screen name, the detections, readings and flags
+type scripting struct {
+	api     scriptapi
+	scripts []*script
+	ctx     *rulecontext // what the hooks being called now are about
+	acted   bool         // a hook did something to the game, so ctx is out of date
+}
+
+func loadscripts(dir string, api scriptapi) (*scripting, error) {
+	s := &scripting{api: api}
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		sc := &script{name: filepath.Base(filename)}
+		sc.thread = &starlark.Thread{
+			Name: sc.name,
+			Print: func(thread *starlark.Thread, msg string) {
+				fmt.Printf("[%v] %v\n", thread.Name, msg)
+			},
+		}
+		sc.globals, err = starlark.ExecFile(sc.thread, filename, nil, s.builtins(sc))
+		if err != nil {
+			return nil, fmt.Errorf("loading %v: %v", filename, err)
+		}
+		s.scripts = append(s.scripts, sc)
+		fmt.Printf("Loaded script %v\n", sc.name)
+	}
+	return s, nil
+}
+
+// call runs a hook if the script has it, scripts failing are reported and carry on
+func (s *scripting) call(sc *script, fn starlark.Value, args ...starlark.Value) {
+	if _, err := starlark.Call(sc.thread, fn, args, nil); err != nil {
+		if evalerr, ok := err.(*starlark.EvalError); ok {
+			fmt.Printf("Script %v failed: %v\n", sc.name, evalerr.Backtrace())
+		} else {
+			fmt.Printf("Script %v failed: %v\n", sc.name, err)
+		}
+	}
+}
+
+// frame runs the hooks for a newly analyzed screen. It's true if a hook
+// clicked, swiped, pressed a key or ran an action
+func (s *scripting) frame(ctx *rulecontext, previous gamescreen) bool {
+	if s == nil || len(s.scripts) == 0 {
+		return false
+	}
+	s.ctx = ctx
+	s.acted = false
+	state := scriptstate(ctx)
+	for _, sc := range s.scripts {
+		if previous != ctx.screen {
+			if fn, found := sc.globals["on_screen"]; found {
+				s.call(sc, fn, starlark.String(previous.String()), state)
+			}
+		}
+		if fn, found := sc.globals["on_frame"]; found {
+			s.call(sc, fn, state)
+		}
+		for _, t := range sc.timers {
+			if ctx.now.Sub(t.last) >= t.every {
+				t.last = ctx.now
+				s.call(sc, t.fn, state)
+			}
+		}
+	}
+	return s.acted
+}
+
+// number takes ints as well as floats from scripts
+type number float64
+
+func (n *number) Unpack(v starlark.Value) error {
+	f, ok := starlark.AsFloat(v)
+	if !ok {
+		return fmt.Errorf("got %s, want number", v.Type())
+	}
+	*n = number(f)
+	return nil
+}
+
+// scriptstate is the state as scripts see it, positions as fractions of the screen
+func scriptstate(ctx *rulecontext) starlark.Value {
+	position := func(p image.Point) starlark.Tuple {
+		return starlark.Tuple{
+			starlark.Float(float64(p.X) / float64(ctx.size.X)),
+			starlark.Float(float64(p.Y) / float64(ctx.size.Y)),
+		}
+	}
+	detections := starlark.NewDict(len(ctx.seen))
+	for name, p := range ctx.seen {
+		detections.SetKey(starlark.String(name), position(p))
+	}
+	readings := starlark.NewDict(len(ctx.readings))
+	for name, rd := range ctx.readings {
+		readings.SetKey(starlark.String(name), starlark.Float(rd.value))
+	}
+	flags := starlark.NewDict(len(ctx.flags))
+	for name, set := range ctx.flags {
+		flags.SetKey(starlark.String(name), starlark.Bool(set))
+	}
+	return starlarkstruct.FromStringDict(starlark.String("state"), starlark.StringDict{
+		"screen":     starlark.String(ctx.screen.String()),
+		"detections": detections,
+		"readings":   readings,
+		"flags":      flags,
+		"time":       starlark.MakeInt64(ctx.now.Unix()),
+	})
+}
+
+func (s *scripting) builtins(sc *script) starlark.StringDict {
+	point := func(x, y number) image.Point {
+		return image.Pt(int(float64(x)*float64(s.ctx.size.X)), int(float64(y)*float64(s.ctx.size.Y)))
+	}
+	hooked := func(name string) error {
+		if s.ctx == nil {
+			return fmt.Errorf("%v can only be used in hooks", name)
+		}
+		return nil
+	}
+
+	return starlark.StringDict{
+		// click("ok", repeat=1) clicks a template or group if it's on screen
+		"click": starlark.NewBuiltin("click", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			repeat := 1
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "repeat?", &repeat); err != nil {
+				return nil, err
+			}
+			if err := hooked(b.Name()); err != nil {
+				return nil, err
+			}
+			p, found := s.api.engine.lookup(s.ctx.seen, name)
+			if found {
+				s.api.click(p, repeat)
+				s.acted = true
+			}
+			return starlark.Bool(found), nil
+		}),
+		// tap(x, y) clicks a place on screen, as fractions of its size
+		"tap": starlark.NewBuiltin("tap", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var x, y number
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x", &x, "y", &y); err != nil {
+				return nil, err
+			}
+			if err := hooked(b.Name()); err != nil {
+				return nil, err
+			}
+			s.api.click(point(x, y), 1)
+			s.acted = true
+			return starlark.None, nil
+		}),
+		// swipe(x1, y1, x2, y2) drags across the screen
+		"swipe": starlark.NewBuiltin("swipe", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var x1, y1, x2, y2 number
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "x1", &x1, "y1", &y1, "x2", &x2, "y2", &y2); err != nil {
+				return nil, err
+			}
+			if err := hooked(b.Name()); err != nil {
+				return nil, err
+			}
+			s.api.drag(point(x1, y1), point(x2, y2))
+			s.acted = true
+			return starlark.None, nil
+		}),
+		// key("home") presses an emulator key
+		"key": starlark.NewBuiltin("key", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+				return nil, err
+			}
+			if err := hooked(b.Name()); err != nil {
+				return nil, err
+			}
+			key, found := s.api.keys[name]
+			if !found {
+				return nil, fmt.Errorf("unknown key %q", name)
+			}
+			s.api.sendkey(key)
+			s.acted = true
+			return starlark.None, nil
+		}),
+		// wait(seconds)
+		"wait": starlark.NewBuiltin("wait", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var seconds number
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "seconds", &seconds); err != nil {
+				return nil, err
+			}
+			if seconds > 60 {
+				return nil, fmt.Errorf("won't wait more than a minute")
+			}
+			time.Sleep(time.Duration(float64(seconds) * float64(time.Second)))
+			return starlark.None, nil
+		}),
+		// action("watch_ad", "ad_offer_watch_button") does what a rule with that action would
+		"action": starlark.NewBuiltin("action", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name, target string
+			repeat := 1
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "target?", &target, "repeat?", &repeat); err != nil {
+				return nil, err
+			}
+			if err := hooked(b.Name()); err != nil {
+				return nil, err
+			}
+			action, found := s.api.actions[name]
+			if !found {
+				return nil, fmt.Errorf("unknown action %q", name)
+			}
+			var p image.Point
+			if target != "" {
+				if p, found = s.api.engine.lookup(s.ctx.seen, target); !found {
+					return starlark.False, nil
+				}
+			}
+			fmt.Printf("Script %v: %v %v\n", sc.name, name, target)
+			action(&rule{Name: sc.name, Action: name, Target: target, Repeat: repeat}, s.ctx, p)
+			s.acted = true
+			return starlark.True, nil
+		}),
+		// disable_rule("hatch chickens") and enable_rule(...) turn rules off and on
+		"disable_rule": starlark.NewBuiltin("disable_rule", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+				return nil, err
+			}
+			return starlark.None, s.api.engine.disable(name, sc.name)
+		}),
+		"enable_rule": starlark.NewBuiltin("enable_rule", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+				return nil, err
+			}
+			return starlark.None, s.api.engine.enable(name)
+		}),
+		// every("30s", fn) calls fn(state) on a timer
+		"every": starlark.NewBuiltin("every", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var interval string
+			var fn starlark.Callable
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "interval", &interval, "fn", &fn); err != nil {
+				return nil, err
+			}
+			every, err := time.ParseDuration(interval)
+			if err != nil {
+				return nil, err
+			}
+			sc.timers = append(sc.timers, &scripttimer{every: every, fn: fn, last: time.Now()})
+			return starlark.None, nil
+		}),
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"image"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// session is what behaviours that work through menus (research, missions
+// ...) use: fresh screens, finding templates, reading text and clicking,
+// all in detection coordinates
+type session struct {
+	capture   func() gocv.Mat   // a screen captured after the call, close it when done
+	screen    func() gamescreen // how a screen analyzed after the call was classified
+	templates map[string]*template
+	groups    map[string][]string // same as in the rules
+	reader    *ocr
+	click     func(p image.Point, repeat int)
+	drag      func(from, to image.Point)
+	press     func(p image.Point) // hold down until release
+	release   func(p image.Point)
+	back      func() // the emulator back key
+}
+
+// names is the templates a name stands for, itself or the members of a group
+func (s *session) names(name string) []string {
+	if members, found := s.groups[name]; found {
+		return members
+	}
+	return []string{name}
+}
+
+// findall returns where a template or group matches, best first
+func (s *session) findall(mat gocv.Mat, name string, limit int) []image.Rectangle {
+	var rects []image.Rectangle
+	for _, n := range s.names(name) {
+		if t, found := s.templates[n]; found {
+			rects = append(rects, matchall(mat, t, limit-len(rects))...)
+		}
+	}
+	return rects
+}
+
+func (s *session) find(mat gocv.Mat, name string) (image.Rectangle, bool) {
+	if rects := s.findall(mat, name, 1); len(rects) > 0 {
+		return rects[0], true
+	}
+	return image.Rectangle{}, false
+}
+
+func center(r image.Rectangle) image.Point {
+	return image.Pt((r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2)
+}
+
+// clickon clicks a template if it's on a fresh screen, after waiting for things to settle
+func (s *session) clickon(name string, settle time.Duration) bool {
+	mat := s.capture()
+	defer mat.Close()
+	r, found := s.find(mat, name)
+	if found {
+		s.click(center(r), 1)
+		time.Sleep(settle)
+	}
+	return found
+}
+
+// leave closes whatever menu is open, with its close button or the back key
+func (s *session) leave() {
+	if !s.clickon("close", time.Second) {
+		s.back()
+		time.Sleep(time.Second)
+	}
+}
+
+// backtofarm closes menus until the farm shows, going back only as far as needed
+func (s *session) backtofarm() {
+	for i := 0; i < 3; i++ {
+		if s.screen() == screenFarmMain {
+			return
+		}
+		s.leave()
+	}
+}
+
+// relative is an area given as fractions of the screen, from a point
+func relative(mat gocv.Mat, from image.Point, area [4]float64) image.Rectangle {
+	w, h := float64(mat.Cols()), float64(mat.Rows())
+	return image.Rect(
+		from.X+int(area[0]*w), from.Y+int(area[1]*h),
+		from.X+int(area[2]*w), from.Y+int(area[3]*h),
+	).Intersect(image.Rect(0, 0, mat.Cols(), mat.Rows()))
+}
+
+// at is a point given as fractions of the screen
+func at(mat gocv.Mat, p [2]float64) image.Point {
+	return image.Pt(int(p[0]*float64(mat.Cols())), int(p[1]*float64(mat.Rows())))
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// average is an exponentially weighted moving average of durations
+type average struct {
+	value   time.Duration
+	samples int
+}
+
+func (a *average) add(d time.Duration) {
+	if a.samples == 0 {
+		a.value = d
+	} else {
+		a.value = (a.value*9 + d) / 10
+	}
+	a.samples++
+}
+
+// stats are the running numbers on how well the bot is doing, shared
+// between the goroutines
+type stats struct {
+	lock sync.Mutex
+
+	started time.Time
+	frames  int
+
+	pipeline average // frame captured until drone detection is done with it
+	shot     average // frame captured until the mouse goes down
+	gesture  average // mouse down until mouse up
+	shots    int
+
+	gestures [gesturecount]struct {
+		duration            average
+		shots, hits, misses int
+	}
+
+	hits, misses, unknown int
+	regions               [9]struct{ hits, misses int } // by screenregion
+	predictionerror       float64                       // summed, in pixels
+	predictions           int
+}
+
+func newstats() *stats {
+	return &stats{
+		started: time.Now(),
+	}
+}
+
+func (s *stats) frameprocessed(captured time.Time) {
+	s.lock.Lock()
+	s.frames++
+	s.pipeline.add(time.Since(captured))
+	s.lock.Unlock()
+}
+
+func (s *stats) shotfired(captured, down, up time.Time, g shotgesture) {
+	s.lock.Lock()
+	s.shots++
+	s.shot.add(down.Sub(captured))
+	s.gesture.add(up.Sub(down))
+	s.gestures[g].duration.add(up.Sub(down))
+	s.gestures[g].shots++
+	s.lock.Unlock()
+}
+
+// lead is how far ahead of the captured frame a shot lands: the time it
+// takes to get from capture to the mouse going down, plus the gesture itself
+func (s *stats) lead(g shotgesture) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	latency := s.shot.value
+	if s.shot.samples == 0 {
+		latency = s.pipeline.value
+	}
+	return latency + s.gestureof(g)
+}
+
+// gestureof is how long gesture g takes, or any gesture if it hasn't been used yet
+func (s *stats) gestureof(g shotgesture) time.Duration {
+	if s.gestures[g].duration.samples > 0 {
+		return s.gestures[g].duration.value
+	}
+	return s.gesture.value
+}
+
+func (s *stats) shotresult(region int, g shotgesture, outcome shotoutcome, predictionerror float64, measured bool) {
+	s.lock.Lock()
+	switch outcome {
+	case shotHit:
+		s.hits++
+		s.regions[region].hits++
+		s.gestures[g].hits++
+	case shotMiss:
+		s.misses++
+		s.regions[region].misses++
+		s.gestures[g].misses++
+	default:
+		s.unknown++
+	}
+	if measured {
+		s.predictionerror += predictionerror
+		s.predictions++
+	}
+	s.lock.Unlock()
+}
+
+func hitrate(hits, misses int) string {
+	if hits+misses == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", float64(hits)*100/float64(hits+misses))
+}
+
+// accuracy is the hit rate per ninth of the screen, laid out like the screen
+func (s *stats) accuracy() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var result string
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			r := s.regions[row*3+col]
+			result += fmt.Sprintf("%5s (%d/%d) ", hitrate(r.hits, r.misses), r.hits, r.hits+r.misses)
+		}
+		result += "\n"
+	}
+	return result
+}
+
+// bygesture is the hit rate of each gesture that has been used
+func (s *stats) bygesture() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var parts []string
+	for g := shotgesture(0); g < gesturecount; g++ {
+		gs := s.gestures[g]
+		if gs.shots == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%v %v (%d/%d of %d shots, %v)", g, hitrate(gs.hits, gs.misses), gs.hits, gs.hits+gs.misses, gs.shots, gs.duration.value.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s *stats) pipelinetime() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pipeline.value
+}
+
+func (s *stats) gestureduration(g shotgesture) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.gestureof(g)
+}
+
+func (s *stats) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fps := float64(s.frames) / time.Since(s.started).Seconds()
+	var predictionerror float64
+	if s.predictions > 0 {
+		predictionerror = s.predictionerror / float64(s.predictions)
+	}
+	return fmt.Sprintf("%.1f fps, pipeline %v, capture to shot %v, gesture %v, %v shots, hit rate %v (%v hits, %v misses, %v unknown), prediction error %.0fpx",
+		fps,
+		s.pipeline.value.Round(time.Millisecond),
+		s.shot.value.Round(time.Millisecond),
+		s.gesture.value.Round(time.Millisecond),
+		s.shots,
+		hitrate(s.hits, s.misses), s.hits, s.misses, s.unknown,
+		predictionerror)
+}
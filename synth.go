@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// synthconfig sets how varied the generated drone scenes are
+type synthconfig struct {
+	scenes    int
+	frames    int        // per scene
+	fps       float64    // how often the bot would see a frame
+	speed     [2]float64 // pixels per second, at scaley
+	curve     float64    // largest sideways bend of a path, as a fraction of its length
+	occlusion float64    // chance a drone is partly hidden in a frame
+	maxdrones int        // flying in one scene
+	lead      float64    // seconds ahead predictions are checked at
+}
+
+var defaultsynthconfig = synthconfig{
+	scenes:    20,
+	frames:    150,
+	fps:       15,
+	speed:     [2]float64{150, 700},
+	curve:     0.25,
+	occlusion: 0.1,
+	maxdrones: 3,
+	lead:      0.15,
+}
+
+// Ground truth is saved as truth.json in each scene folder
+type synthtruth struct {
+	FPS    float64      `json:"fps"`
+	Frames []synthframe `json:"frames"`
+}
+
+type synthframe struct {
+	File   string       `json:"file"`
+	Drones []synthdrone `json:"drones"`
+}
+
+type synthdrone struct {
+	ID       int  `json:"id"`
+	X        int  `json:"x"`
+	Y        int  `json:"y"`
+	Occluded bool `json:"occluded,omitempty"`
+}
+
+// sprite is a drone cut from a recorded frame, with a mask of the drone
+// pixels so the background around it doesn't come along
+type sprite struct {
+	image, mask gocv.Mat
+}
+
+func (s sprite) size() image.Point {
+	return image.Pt(s.image.Cols(), s.image.Rows())
+}
+
+// flightpath is a quadratic curve from one screen edge to the other
+type flightpath struct {
+	from, via, to [2]float64
+	start         float64 // seconds into the scene
+	duration      float64
+	sprite        int
+}
+
+func (fp flightpath) at(t float64) (image.Point, bool) {
+	f := (t - fp.start) / fp.duration
+	if f < 0 || f > 1 {
+		return image.Point{}, false
+	}
+	var p [2]float64
+	for i := 0; i < 2; i++ {
+		p[i] = (1-f)*(1-f)*fp.from[i] + 2*(1-f)*f*fp.via[i] + f*f*fp.to[i]
+	}
+	return image.Pt(int(p[0]), int(p[1])), true
+}
+
+// loadsynthsources cuts sprites out of labelled frames, and uses the frames
+// labelled as having no drones as backgrounds
+func loadsynthsources(dir string, scaley int, dp droneprofile, kernel gocv.Mat) ([]sprite, []gocv.Mat, error) {
+	labels, err := loaddronelabels(filepath.Join(dir, "labels.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sprites []sprite
+	var backgrounds []gocv.Mat
+	for filename, boxes := range labels {
+		frame := gocv.IMRead(filepath.Join(dir, filename), gocv.IMReadColor)
+		if frame.Empty() {
+			return nil, nil, fmt.Errorf("could not read frame %v", filename)
+		}
+		factor := 1.0
+		if frame.Rows() != scaley {
+			factor = float64(scaley) / float64(frame.Rows())
+			gocv.Resize(frame, &frame, image.Point{}, factor, factor, gocv.InterpolationLanczos4)
+		}
+		if len(boxes) == 0 {
+			backgrounds = append(backgrounds, frame)
+			continue
+		}
+
+		for _, b := range boxes {
+			box := labelrect(b)
+			box = image.Rect(int(float64(box.Min.X)*factor), int(float64(box.Min.Y)*factor),
+				int(float64(box.Max.X)*factor), int(float64(box.Max.Y)*factor))
+			box = box.Intersect(image.Rect(0, 0, frame.Cols(), frame.Rows()))
+			if box.Empty() {
+				continue
+			}
+			region := frame.Region(box)
+			s := sprite{image: region.Clone(), mask: gocv.NewMat()}
+			region.Close()
+
+			black, brown := gocv.NewMat(), gocv.NewMat()
+			lb, ub := dp.Black.scalars()
+			gocv.InRangeWithScalar(s.image, lb, ub, &black)
+			lb, ub = dp.Brown.scalars()
+			gocv.InRangeWithScalar(s.image, lb, ub, &brown)
+			gocv.BitwiseOr(black, brown, &black)
+			gocv.Dilate(black, &s.mask, kernel)
+			black.Close()
+			brown.Close()
+			sprites = append(sprites, s)
+		}
+		frame.Close()
+	}
+
+	if len(sprites) == 0 {
+		return nil, nil, fmt.Errorf("no labelled drones in %v", dir)
+	}
+	if len(backgrounds) == 0 {
+		return nil, nil, fmt.Errorf("no frames labelled without drones in %v to use as backgrounds", dir)
+	}
+	return sprites, backgrounds, nil
+}
+
+// randompath flies in from a random edge to the opposite one, inside the drone band
+func randompath(rnd *rand.Rand, cfg synthconfig, size image.Point, dp droneprofile) flightpath {
+	w, h := float64(size.X), float64(size.Y)
+	top, bottom := dp.BandTop*h, dp.BandBottom*h
+	y := func() float64 { return top + rnd.Float64()*(bottom-top) }
+	x := func() float64 { return rnd.Float64() * w }
+
+	var fp flightpath
+	switch rnd.Intn(4) {
+	case 0:
+		fp.from, fp.to = [2]float64{-20, y()}, [2]float64{w + 20, y()}
+	case 1:
+		fp.from, fp.to = [2]float64{w + 20, y()}, [2]float64{-20, y()}
+	case 2:
+		fp.from, fp.to = [2]float64{x(), top}, [2]float64{x(), bottom}
+	default:
+		fp.from, fp.to = [2]float64{x(), bottom}, [2]float64{x(), top}
+	}
+
+	dx, dy := fp.to[0]-fp.from[0], fp.to[1]-fp.from[1]
+	length := math.Hypot(dx, dy)
+	bend := (rnd.Float64()*2 - 1) * cfg.curve * length
+	fp.via = [2]float64{
+		(fp.from[0]+fp.to[0])/2 - dy/length*bend,
+		(fp.from[1]+fp.to[1])/2 + dx/length*bend,
+	}
+
+	speed := cfg.speed[0] + rnd.Float64()*(cfg.speed[1]-cfg.speed[0])
+	fp.duration = length / speed
+	return fp
+}
+
+// paste draws the sprite centered on p, hiding one half of it if occluded
+func paste(frame *gocv.Mat, s sprite, p image.Point, occluded int) {
+	size := s.size()
+	dst := image.Rectangle{Min: p.Sub(size.Div(2)), Max: p.Sub(size.Div(2)).Add(size)}
+	visible := dst.Intersect(image.Rect(0, 0, frame.Cols(), frame.Rows()))
+	if visible.Empty() {
+		return
+	}
+	src := visible.Sub(dst.Min)
+
+	mask := s.mask.Region(src)
+	defer mask.Close()
+	visiblemask := mask
+	if occluded > 0 {
+		hidden := mask.Clone()
+		defer hidden.Close()
+		half := image.Rect(0, 0, src.Dx(), src.Dy())
+		switch occluded {
+		case 1:
+			half.Max.X = half.Dx() / 2
+		case 2:
+			half.Min.X = half.Dx() / 2
+		case 3:
+			half.Max.Y = half.Dy() / 2
+		default:
+			half.Min.Y = half.Dy() / 2
+		}
+		cover := hidden.Region(half)
+		cover.SetTo(gocv.NewScalar(0, 0, 0, 0))
+		cover.Close()
+		visiblemask = hidden
+	}
+
+	spriteregion := s.image.Region(src)
+	frameregion := frame.Region(visible)
+	spriteregion.CopyToWithMask(&frameregion, visiblemask)
+	frameregion.Close()
+	spriteregion.Close()
+}
+
+// renderscene flies a few drones over bg, handing each frame to use along with
+// where the drones are in it. The frame is closed when use returns. It
+// returns the truth for the scene and how many drones flew
+func renderscene(rnd *rand.Rand, cfg synthconfig, sprites []sprite, bg gocv.Mat, dp droneprofile, use func(gocv.Mat, synthframe) error) (synthtruth, int, error) {
+	size := image.Pt(bg.Cols(), bg.Rows())
+	scenelength := float64(cfg.frames) / cfg.fps
+
+	paths := make([]flightpath, 1+rnd.Intn(cfg.maxdrones))
+	for i := range paths {
+		paths[i] = randompath(rnd, cfg, size, dp)
+		paths[i].start = rnd.Float64() * scenelength / 3
+		paths[i].sprite = rnd.Intn(len(sprites))
+	}
+
+	truth := synthtruth{FPS: cfg.fps}
+	for i := 0; i < cfg.frames; i++ {
+		t := float64(i) / cfg.fps
+		frame := bg.Clone()
+		sf := synthframe{File: fmt.Sprintf("frame_%04d.png", i)}
+		for id, fp := range paths {
+			p, flying := fp.at(t)
+			if !flying {
+				continue
+			}
+			occluded := 0
+			if rnd.Float64() < cfg.occlusion {
+				occluded = 1 + rnd.Intn(4)
+			}
+			paste(&frame, sprites[fp.sprite], p, occluded)
+			if p.In(image.Rect(0, 0, size.X, size.Y)) {
+				sf.Drones = append(sf.Drones, synthdrone{ID: id + 1, X: p.X, Y: p.Y, Occluded: occluded > 0})
+			}
+		}
+		err := use(frame, sf)
+		frame.Close()
+		if err != nil {
+			return truth, len(paths), err
+		}
+		truth.Frames = append(truth.Frames, sf)
+	}
+	return truth, len(paths), nil
+}
+
+// generatesynth writes scenes of drones flying over recorded backgrounds,
+// each in its own folder with the frames and a truth.json
+func generatesynth(dir, out string, cfg synthconfig, scaley int, dp droneprofile, kernel gocv.Mat) error {
+	sprites, backgrounds, err := loadsynthsources(dir, scaley, dp, kernel)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, s := range sprites {
+			s.image.Close()
+			s.mask.Close()
+		}
+		for _, bg := range backgrounds {
+			bg.Close()
+		}
+	}()
+
+	// The same scenes every time, so runs can be compared
+	rnd := rand.New(rand.NewSource(1))
+	for scene := 0; scene < cfg.scenes; scene++ {
+		scenedir := filepath.Join(out, fmt.Sprintf("scene_%03d", scene))
+		if err = os.MkdirAll(scenedir, 0755); err != nil {
+			return err
+		}
+
+		bg := backgrounds[rnd.Intn(len(backgrounds))]
+		truth, drones, err := renderscene(rnd, cfg, sprites, bg, dp, func(frame gocv.Mat, sf synthframe) error {
+			if !gocv.IMWrite(filepath.Join(scenedir, sf.File), frame) {
+				return fmt.Errorf("could not save %v", sf.File)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(truth, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(scenedir, "truth.json"), data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Generated %v with %v drones\n", scenedir, drones)
+	}
+	return nil
+}
+
+// synthreport is how the drone code did on the generated scenes
+type synthreport struct {
+	drones, detected   int // visible drones, and how many of them were found
+	occluded, occfound int // same for partly hidden drones
+	falsepositives     int
+	idswitches         int
+	predictionerrors   []float64
+	predictionsmissing int // confirmed tracks with no drone near them
+}
+
+func (r synthreport) String() string {
+	rate := func(found, total int) string {
+		if total == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", float64(found)*100/float64(total))
+	}
+	sort.Float64s(r.predictionerrors)
+	var mean float64
+	for _, e := range r.predictionerrors {
+		mean += e
+	}
+	if len(r.predictionerrors) > 0 {
+		mean /= float64(len(r.predictionerrors))
+	}
+	return fmt.Sprintf("detection rate %v (%v of %v), partly hidden %v (%v of %v), %v false positives, %v track id switches\n"+
+		"prediction error mean %.1fpx, median %.1fpx, 90th percentile %.1fpx over %v predictions, %v tracks not on a drone",
+		rate(r.detected, r.drones), r.detected, r.drones,
+		rate(r.occfound, r.occluded), r.occfound, r.occluded,
+		r.falsepositives, r.idswitches,
+		mean, percentile(r.predictionerrors, 0.5), percentile(r.predictionerrors, 0.9), len(r.predictionerrors),
+		r.predictionsmissing)
+}
+
+// testsynth runs detection, tracking and prediction over every scene folder
+// in dir, like the drone goroutine would, and compares with the truth
+func testsynth(dir string, cfg synthconfig, dp droneprofile, kernel gocv.Mat, usemotion bool) (synthreport, error) {
+	var report synthreport
+	scenes, err := filepath.Glob(filepath.Join(dir, "scene_*", "truth.json"))
+	if err != nil {
+		return report, err
+	}
+	if len(scenes) == 0 {
+		return report, fmt.Errorf("no scenes in %v", dir)
+	}
+
+	for _, truthfile := range scenes {
+		data, err := os.ReadFile(truthfile)
+		if err != nil {
+			return report, err
+		}
+		var truth synthtruth
+		if err = json.Unmarshal(data, &truth); err != nil {
+			return report, fmt.Errorf("parsing %v: %v", truthfile, err)
+		}
+		scenedir := filepath.Dir(truthfile)
+		err = scorescene(&report, truth, cfg, dp, kernel, usemotion, func(sf synthframe) (gocv.Mat, error) {
+			frame := gocv.IMRead(filepath.Join(scenedir, sf.File), gocv.IMReadColor)
+			if frame.Empty() {
+				return frame, fmt.Errorf("could not read %v", sf.File)
+			}
+			return frame, nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// scorescene runs detection, tracking and prediction over the frames of a
+// scene like the drone goroutine would, and adds how it did to report. read
+// gets a frame, which is closed when done with
+func scorescene(report *synthreport, truth synthtruth, cfg synthconfig, dp droneprofile, kernel gocv.Mat, usemotion bool, read func(synthframe) (gocv.Mat, error)) error {
+	leadframes := int(cfg.lead*truth.FPS + 0.5)
+
+	var t *tracker
+	var motion *motiondetector
+	start := time.Unix(0, 0)
+	trackof := make(map[int]int) // truth drone to the track last found on it
+	for i, sf := range truth.Frames {
+		frame, err := read(sf)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			t = newtracker(frame.Cols())
+			if usemotion {
+				motion = newmotiondetector(frame.Cols())
+			}
+		}
+		gate := float64(frame.Cols()) / 20
+		size := image.Pt(frame.Cols(), frame.Rows())
+
+		var detections []image.Point
+		for _, dd := range motion.detect(frame, dp, kernel, nil) {
+			if !dp.masked(dd.position, size, screenFarmMain) {
+				detections = append(detections, dd.position)
+			}
+		}
+		frame.Close()
+		now := start.Add(time.Duration(float64(i) / truth.FPS * float64(time.Second)))
+		tracks := t.update(detections, now)
+
+		// Nearest detection to each drone, each detection used once
+		used := make([]bool, len(detections))
+		for _, sd := range sf.Drones {
+			if dp.masked(image.Pt(sd.X, sd.Y), size, screenFarmMain) {
+				// Not supposed to be found there
+				continue
+			}
+			best, bestdistance := -1, gate
+			for j, d := range detections {
+				if dist := math.Hypot(float64(d.X-sd.X), float64(d.Y-sd.Y)); !used[j] && dist < bestdistance {
+					best, bestdistance = j, dist
+				}
+			}
+			report.drones++
+			if sd.Occluded {
+				report.occluded++
+			}
+			if best == -1 {
+				continue
+			}
+			used[best] = true
+			report.detected++
+			if sd.Occluded {
+				report.occfound++
+			}
+			if tr := tracks[best]; tr.state == trackConfirmed {
+				if last, seen := trackof[sd.ID]; seen && last != tr.id {
+					report.idswitches++
+				}
+				trackof[sd.ID] = tr.id
+			}
+		}
+		for _, u := range used {
+			if !u {
+				report.falsepositives++
+			}
+		}
+
+		// Where confirmed tracks think their drone will be, against where it went
+		if i+leadframes >= len(truth.Frames) {
+			continue
+		}
+		later := truth.Frames[i+leadframes]
+		for _, tr := range t.tracks {
+			if tr.state != trackConfirmed || !tr.updated {
+				continue
+			}
+			current := tr.positions[len(tr.positions)-1]
+			id := 0
+			nearest := gate
+			for _, sd := range sf.Drones {
+				if dist := math.Hypot(float64(current.X-sd.X), float64(current.Y-sd.Y)); dist < nearest {
+					id, nearest = sd.ID, dist
+				}
+			}
+			if id == 0 {
+				report.predictionsmissing++
+				continue
+			}
+			predicted := tr.filter.peek(float64(leadframes) / truth.FPS)
+			for _, sd := range later.Drones {
+				if sd.ID == id {
+					report.predictionerrors = append(report.predictionerrors, math.Hypot(float64(predicted.X-sd.X), float64(predicted.Y-sd.Y)))
+				}
+			}
+		}
+	}
+	motion.close()
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// testsprite is a drone the default profile finds, a black body with a brown
+// part overlapping its lower edge, on the same green as testbackground
+func testsprite(dp droneprofile) sprite {
+	s := sprite{
+		image: gocv.NewMatWithSizeFromScalar(gocv.NewScalar(60, 160, 80, 0), 16, 24, gocv.MatTypeCV8UC3),
+		mask:  gocv.NewMatWithSizeFromScalar(gocv.NewScalar(255, 0, 0, 0), 16, 24, gocv.MatTypeCV8U),
+	}
+	fill := func(r image.Rectangle, cr colorrange) {
+		region := s.image.Region(r)
+		region.SetTo(gocv.NewScalar((cr.Min[0]+cr.Max[0])/2, (cr.Min[1]+cr.Max[1])/2, (cr.Min[2]+cr.Max[2])/2, 0))
+		region.Close()
+	}
+	fill(image.Rect(2, 0, 22, 10), dp.Black)
+	fill(image.Rect(6, 8, 18, 14), dp.Brown)
+	return s
+}
+
+func testbackground(dp droneprofile) gocv.Mat {
+	return gocv.NewMatWithSizeFromScalar(gocv.NewScalar(60, 160, 80, 0), dp.Height, dp.Height*9/16, gocv.MatTypeCV8UC3)
+}
+
+// Runs detection and tracking over generated scenes, the same ones every
+// time, and checks they still find and follow the drones
+func TestSynthScenes(t *testing.T) {
+	dp := defaultdroneprofile
+	kernel := gocv.Ones(5, 5, gocv.MatTypeCV8U)
+	defer kernel.Close()
+	s := testsprite(dp)
+	defer s.image.Close()
+	defer s.mask.Close()
+	bg := testbackground(dp)
+	defer bg.Close()
+
+	cfg := defaultsynthconfig
+	cfg.scenes = 5
+	rnd := rand.New(rand.NewSource(1))
+	var report synthreport
+	for scene := 0; scene < cfg.scenes; scene++ {
+		var frames []gocv.Mat
+		truth, _, err := renderscene(rnd, cfg, []sprite{s}, bg, dp, func(frame gocv.Mat, sf synthframe) error {
+			frames = append(frames, frame.Clone())
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Frames are read in order
+		next := 0
+		err = scorescene(&report, truth, cfg, dp, kernel, false, func(sf synthframe) (gocv.Mat, error) {
+			next++
+			return frames[next-1].Clone(), nil
+		})
+		for _, frame := range frames {
+			frame.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Log(report)
+
+	if report.drones == 0 {
+		t.Fatal("no drones flew")
+	}
+	if rate := float64(report.detected) / float64(report.drones); rate < 0.8 {
+		t.Errorf("detection rate %.2f, want at least 0.8", rate)
+	}
+	if report.falsepositives > report.drones/20 {
+		t.Errorf("%v false positives for %v drones, want at most 5%%", report.falsepositives, report.drones)
+	}
+	if report.idswitches > cfg.scenes {
+		t.Errorf("%v track id switches in %v scenes, want at most one a scene", report.idswitches, cfg.scenes)
+	}
+	if len(report.predictionerrors) == 0 {
+		t.Fatal("no predictions from confirmed tracks")
+	}
+	sort.Float64s(report.predictionerrors)
+	if median := percentile(report.predictionerrors, 0.5); median > 10 {
+		t.Errorf("median prediction error %.1fpx, want at most 10px", median)
+	}
+}
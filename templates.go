@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"gocv.io/x/gocv"
+)
+
+// loadtemplate reads a PNG from fsys, builds the alpha mask if there is one,
+// and scales it to scaley if the filename carries the source height (name.2160.png)
+func loadtemplate(fsys fs.FS, filename string, scaley int, detector *gocv.ORB) (string, *template, error) {
+	imagedata, err := fsys.Open(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	defer imagedata.Close()
+
+	loadedimage, err := png.Decode(imagedata)
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding %v: %v", filename, err)
+	}
+
+	mat, _ := gocv.ImageToMatRGB(loadedimage)
+
+	var alphaimage *image.Gray
+	if loadedimage.ColorModel() == color.NRGBAModel {
+		alphaimage = image.NewGray(loadedimage.Bounds())
+		for x := 0; x < loadedimage.Bounds().Dx(); x++ {
+			for y := 0; y < loadedimage.Bounds().Dy(); y++ {
+				_, _, _, a := loadedimage.At(x, y).RGBA()
+				av := uint8(a)
+				alphaimage.Set(x, y, color.RGBA{av, av, av, av})
+			}
+		}
+	}
+
+	mask := gocv.NewMat()
+	if alphaimage != nil {
+		tmask, _ := gocv.ImageGrayToMatGray(alphaimage)
+		tmask.ConvertTo(&mask, gocv.MatTypeCV32FC3)
+		tmask.Close()
+	}
+
+	var kps []gocv.KeyPoint
+	if detector != nil {
+		var m gocv.Mat
+		kps, m = detector.DetectAndCompute(mat, mask)
+		m.Close()
+	}
+
+	basename, height, found := strings.Cut(strings.TrimSuffix(path.Base(filename), ".png"), ".")
+	if found {
+		h, _ := strconv.ParseInt(height, 10, 64)
+		factor := float64(scaley) / float64(h)
+		oldx, oldy := mat.Cols(), mat.Rows()
+		gocv.Resize(mat, &mat, image.Point{}, factor, factor, gocv.InterpolationLanczos4)
+		fmt.Printf("Resized %s from %d,%d to %d,%d\n", basename, oldx, oldy, mat.Cols(), mat.Rows())
+		if mask.Cols() > 0 {
+			gocv.Resize(mask, &mask, image.Point{}, factor, factor, gocv.InterpolationLanczos4)
+		}
+	}
+
+	return basename, &template{
+		mat:       mat,
+		mask:      mask,
+		keypoints: kps,
+	}, nil
+}
+
+// matchall finds every place the template matches, best first
+func matchall(screen gocv.Mat, t *template, limit int) []image.Rectangle {
+	resultmat := gocv.NewMat()
+	defer resultmat.Close()
+	gocv.MatchTemplate(screen, t.mat, &resultmat, gocv.TmSqdiffNormed, t.mask)
+
+	var rects []image.Rectangle
+	for len(rects) < limit {
+		confidence, _, loc, _ := gocv.MinMaxLoc(resultmat)
+		if confidence >= t.threshold {
+			break
+		}
+		rects = append(rects, image.Rect(loc.X, loc.Y, loc.X+t.mat.Cols(), loc.Y+t.mat.Rows()))
+
+		// Blank out around this match, so the next best is somewhere else
+		blank := image.Rect(loc.X-t.mat.Cols()/2, loc.Y-t.mat.Rows()/2, loc.X+t.mat.Cols()/2+1, loc.Y+t.mat.Rows()/2+1)
+		region := resultmat.Region(blank.Intersect(image.Rect(0, 0, resultmat.Cols(), resultmat.Rows())))
+		region.SetTo(gocv.NewScalar(1, 1, 1, 1))
+		region.Close()
+	}
+	return rects
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"image"
+	"math"
+	"time"
+)
+
+type trackstate int
+
+const (
+	trackTentative trackstate = iota
+	trackConfirmed
+	trackDeleted
+)
+
+// maxpositions is the most sightings a track remembers
+const maxpositions = 200
+
+type track struct {
+	id     int
+	state  trackstate
+	filter kalman
+
+	hits, misses int
+	updated      bool // matched to a detection in the latest frame
+
+	firstseen, lastseen time.Time
+	positions           []image.Point // measured, for drawing and sanity checks
+	times               []time.Time   // when each position was captured
+	zaps                []zap
+
+	elitevotes, votes int  // sightings that looked like an elite drone, out of all of them
+	preaimed          bool // velocity taken from the heatmap, good to shoot before it's confirmed
+}
+
+// elite is decided by majority over a few sightings, as a single frame can be misleading
+func (tr *track) elite() bool {
+	return tr.votes >= 3 && tr.elitevotes*2 > tr.votes
+}
+
+// speed in pixels per second
+func (tr *track) speed() float64 {
+	vx, vy := tr.filter.velocity()
+	return math.Hypot(vx, vy)
+}
+
+// tracker follows every drone on screen. Tracks are matched to detections
+// with the Hungarian algorithm on the distance to where their Kalman filter
+// expects them, confirmed after a few hits and dropped after a few misses
+type tracker struct {
+	nextid int
+	tracks []*track
+	ended  []*track // tracks deleted in the latest update
+	last   time.Time
+
+	gate        float64       // furthest a detection can be from a prediction to match it, in pixels
+	confirmhits int           // hits before a tentative track is confirmed
+	maxmisses   int           // frames a confirmed track may go undetected
+	maxage      time.Duration // tracks not seen for this long are gone, however many frames that is
+}
+
+func newtracker(screenwidth int) *tracker {
+	return &tracker{
+		nextid:      1,
+		gate:        float64(screenwidth) / 5,
+		confirmhits: 3,
+		maxmisses:   10,
+		maxage:      time.Second,
+	}
+}
+
+// update advances all tracks to now and assigns the detections in this frame,
+// returning the track each detection ended up on
+func (t *tracker) update(detections []image.Point, now time.Time) []*track {
+	dt := 0.0
+	if !t.last.IsZero() {
+		dt = now.Sub(t.last).Seconds()
+	}
+	t.last = now
+
+	for _, tr := range t.tracks {
+		tr.filter.predict(dt)
+		tr.updated = false
+	}
+
+	var assignment []int
+	if len(t.tracks) > 0 && len(detections) > 0 {
+		cost := make([][]float64, len(t.tracks))
+		for i, tr := range t.tracks {
+			cost[i] = make([]float64, len(detections))
+			predicted := tr.filter.position()
+			for j, d := range detections {
+				cost[i][j] = math.Hypot(float64(d.X-predicted.X), float64(d.Y-predicted.Y))
+			}
+		}
+		assignment = hungarian(cost, t.gate)
+	}
+
+	assigned := make([]*track, len(detections))
+	for i, tr := range t.tracks {
+		if assignment != nil && assignment[i] != -1 {
+			d := detections[assignment[i]]
+			assigned[assignment[i]] = tr
+			tr.filter.update(d)
+			tr.positions = append(tr.positions, d)
+			tr.times = append(tr.times, now)
+			// A track that lives on, like a prop taken for a drone, keeps the first two
+			// sightings, which tell where it came from, and the latest ones
+			if len(tr.positions) > maxpositions {
+				keep := maxpositions / 2
+				tr.positions = append(tr.positions[:2], tr.positions[len(tr.positions)-keep:]...)
+				tr.times = append(tr.times[:2], tr.times[len(tr.times)-keep:]...)
+			}
+			tr.hits++
+			tr.misses = 0
+			tr.updated = true
+			tr.lastseen = now
+			if tr.state == trackTentative && tr.hits >= t.confirmhits {
+				tr.state = trackConfirmed
+			}
+			continue
+		}
+
+		tr.misses++
+		if tr.state == trackTentative || tr.misses > t.maxmisses || now.Sub(tr.lastseen) > t.maxage {
+			tr.state = trackDeleted
+		}
+	}
+
+	for j, d := range detections {
+		if assigned[j] != nil {
+			continue
+		}
+		assigned[j] = &track{
+			id:        t.nextid,
+			state:     trackTentative,
+			filter:    newkalman(d, 2e5, 4, 600*600),
+			hits:      1,
+			updated:   true,
+			firstseen: now,
+			lastseen:  now,
+			positions: []image.Point{d},
+			times:     []time.Time{now},
+		}
+		t.tracks = append(t.tracks, assigned[j])
+		t.nextid++
+	}
+
+	t.ended = nil
+	live := t.tracks[:0]
+	for _, tr := range t.tracks {
+		if tr.state != trackDeleted {
+			live = append(live, tr)
+		} else if tr.hits >= t.confirmhits {
+			t.ended = append(t.ended, tr)
+		}
+	}
+	t.tracks = live
+
+	return assigned
+}
+
+// positionat interpolates where the track was seen at a given time, false
+// if that is outside what was observed
+func (tr *track) positionat(at time.Time) (image.Point, bool) {
+	for i := 1; i < len(tr.times); i++ {
+		if tr.times[i].Before(at) {
+			continue
+		}
+		if tr.times[i-1].After(at) {
+			return image.Point{}, false
+		}
+		span := tr.times[i].Sub(tr.times[i-1]).Seconds()
+		f := 0.0
+		if span > 0 {
+			f = at.Sub(tr.times[i-1]).Seconds() / span
+		}
+		a, b := tr.positions[i-1], tr.positions[i]
+		return image.Pt(a.X+int(float64(b.X-a.X)*f), a.Y+int(float64(b.Y-a.Y)*f)), true
+	}
+	return image.Point{}, false
+}
+
+// snapshot copies the tracks, so they can be drawn while the tracker carries on
+func (t *tracker) snapshot() []track {
+	tracks := make([]track, len(t.tracks))
+	for i, tr := range t.tracks {
+		tracks[i] = *tr
+		tracks[i].positions = append([]image.Point(nil), tr.positions...)
+		tracks[i].times = append([]time.Time(nil), tr.times...)
+		tracks[i].zaps = append([]zap(nil), tr.zaps...)
+	}
+	return tracks
+}
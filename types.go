@@ -14,23 +14,48 @@ type template struct {
 	keypoints []gocv.KeyPoint
 }
 
-type zap struct {
-	position  image.Point
-	predicted image.Point
-}
+type shotoutcome int
+
+const (
+	shotPending shotoutcome = iota
+	shotHit
+	shotMiss
+	shotUnknown // the drone left the screen or we lost it, can't tell
+)
 
-type takedowninfo struct {
-	timeout         time.Time
-	initialposition image.Point
-	positions       []image.Point
-	zaps            []zap
-	seencount       int
+type zap struct {
+	position   image.Point
+	predicted  image.Point
+	fired      time.Time // mouse down
+	lands      time.Time // when the prediction was for
+	gesture    shotgesture
+	outcome    shotoutcome
+	baseline   int  // reward coloured pixels around the target when fired
+	rewardseen bool // a reward popped up there after the drone vanished
 }
 
 type result struct {
 	name       string
+	locale     string // set if matched by a translated template
 	confidence float32
 	threshold  float32
 	location   image.Point
 	rect       image.Rectangle
 }
+
+// reading is a number read off the screen with OCR. Confidence goes from 0
+// (unreadable) to 1 (perfect glyph matches), durations are in seconds
+type reading struct {
+	text       string
+	value      float64
+	confidence float32
+	time       time.Time
+}
+
+type gamestate struct {
+	readings map[string]reading
+
+	screen           gamescreen
+	screenconfidence float32
+	screenchanged    time.Time
+}